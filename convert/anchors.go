@@ -0,0 +1,224 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"google.golang.org/api/docs/v1"
+)
+
+// bookmarkAnchorPrefix starts the anchors links to bookmarks are exported
+// with. Docs does not expose where bookmarks are, so the anchors only round
+// trip to the bookmark rather than resolving in rendered Markdown.
+const bookmarkAnchorPrefix = "bookmark-"
+
+// headingLevel returns the Markdown heading level of a paragraph style, or 0
+// if it is not a heading.
+func headingLevel(namedStyleType string) int {
+	switch namedStyleType {
+	case "TITLE":
+		return 1
+	case "SUBTITLE":
+		return 2
+	}
+	var level int
+	if _, err := fmt.Sscanf(namedStyleType, "HEADING_%d", &level); err != nil {
+		return 0
+	}
+	return level
+}
+
+// headingKey identifies a heading of a document by the ID of its tab, which
+// is empty for documents retrieved without their tabs' content, and its Docs
+// heading ID.
+type headingKey struct {
+	tabID, headingID string
+}
+
+// addHeadingAnchors adds the anchors the document's headings are given by
+// Markdown renderers to mc.anchors. The anchors are generated by ids the same
+// way as goldmark's automatic heading IDs, which are built from the heading's
+// Markdown source rather than its text, and which are numbered through the
+// whole Markdown file. titleHeading is set if title is exported as a heading
+// before the body.
+func (mc *MarkdownConverter) addHeadingAnchors(ids parser.IDs, doc *docs.Document, title string, titleHeading bool) {
+	// Footnote references in headings are collected when the body is
+	// exported.
+	footnotes := mc.footnotes
+	defer func() { mc.footnotes = footnotes }()
+	if titleHeading {
+		ids.Generate([]byte(title), ast.KindHeading)
+	}
+	if doc.Body == nil {
+		return
+	}
+	for i, s := range doc.Body.Content {
+		var style *docs.ParagraphStyle
+		if s.Paragraph != nil {
+			style = s.Paragraph.ParagraphStyle
+		}
+		if style == nil || headingLevel(style.NamedStyleType) == 0 {
+			// Footnotes are numbered in order, so those before a
+			// heading change the Markdown of references in it.
+			mc.footnotes = append(mc.footnotes, footnoteReferences(doc.Body.Content[i:i+1])...)
+			continue
+		}
+		anchor := string(ids.Generate([]byte(mc.headingMarkdown(s.Paragraph)), ast.KindHeading))
+		if style.HeadingId != "" {
+			mc.anchors[headingKey{mc.tabID, style.HeadingId}] = anchor
+		}
+	}
+}
+
+// footnoteReferences returns the footnote references in content, including
+// those in tables, in order.
+func footnoteReferences(content []*docs.StructuralElement) []*docs.FootnoteReference {
+	var refs []*docs.FootnoteReference
+	for _, s := range content {
+		switch {
+		case s.Paragraph != nil:
+			for _, elem := range s.Paragraph.Elements {
+				if elem.FootnoteReference != nil {
+					refs = append(refs, elem.FootnoteReference)
+				}
+			}
+		case s.Table != nil:
+			for _, row := range s.Table.TableRows {
+				for _, cell := range row.TableCells {
+					refs = append(refs, footnoteReferences(cell.Content)...)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// headingMarkdown returns the Markdown a heading's text is exported as.
+func (mc *MarkdownConverter) headingMarkdown(p *docs.Paragraph) string {
+	return mc.breakLines(escapeLineStart(mc.elementsAsMarkdown(p.Elements, false)), true, 0)
+}
+
+// headingStyles returns the paragraph styles of the document's headings,
+// keyed by Docs heading ID.
+func headingStyles(doc *docs.Document) map[string]*docs.ParagraphStyle {
+	styles := map[string]*docs.ParagraphStyle{}
+	if doc.Body == nil {
+		return styles
+	}
+	for _, s := range doc.Body.Content {
+		if s.Paragraph != nil && s.Paragraph.ParagraphStyle != nil && s.Paragraph.ParagraphStyle.HeadingId != "" {
+			styles[s.Paragraph.ParagraphStyle.HeadingId] = s.Paragraph.ParagraphStyle
+		}
+	}
+	return styles
+}
+
+// linkDestination returns the Markdown destination of a Docs link. Links to
+// headings are written as links to the heading's anchor.
+func (mc *MarkdownConverter) linkDestination(link *docs.Link) string {
+	switch {
+	case link.Url != "":
+		return link.Url
+	case link.Heading != nil || link.HeadingId != "":
+		if anchor, ok := mc.anchors[mc.linkedHeading(link)]; ok {
+			return "#" + anchor
+		}
+	case link.Bookmark != nil || link.BookmarkId != "":
+		id := link.BookmarkId
+		if link.Bookmark != nil {
+			id = link.Bookmark.Id
+		}
+		return "#" + bookmarkAnchorPrefix + strings.TrimPrefix(id, "id.")
+	}
+	return ""
+}
+
+// linkedHeading returns the heading a link points to. Documents retrieved
+// with their tabs' content link to headings with Link.Heading, which names
+// the heading's tab, and leave Link.HeadingId empty.
+func (mc *MarkdownConverter) linkedHeading(link *docs.Link) headingKey {
+	if link.Heading == nil {
+		return headingKey{mc.tabID, link.HeadingId}
+	}
+	key := headingKey{link.Heading.TabId, link.Heading.Id}
+	if key.tabID == "" {
+		key.tabID = mc.tabID
+	}
+	return key
+}
+
+// markdownHeading is a heading of a Markdown document.
+type markdownHeading struct {
+	text string
+	// anchor is the heading's ID, generated by the parser.
+	anchor string
+}
+
+// markdownHeadings returns the headings of a Markdown document, in order.
+func markdownHeadings(doc ast.Node, source []byte) []markdownHeading {
+	var headings []markdownHeading
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if h, ok := node.(*ast.Heading); ok && entering {
+			heading := markdownHeading{text: plainText(h, source)}
+			if id, ok := h.AttributeString("id"); ok {
+				if b, ok := id.([]byte); ok {
+					heading.anchor = string(b)
+				}
+			}
+			headings = append(headings, heading)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return headings
+}
+
+// headingLink is a link to a heading written into the document, to be
+// linked to the heading once the heading's ID is known.
+type headingLink struct {
+	start, end int64
+	// heading is the position of the linked heading among the headings of
+	// the Markdown document.
+	heading int
+}
+
+// headingLinkRequests returns the requests linking text to the headings
+// written before end in doc, which are in the same order as the headings of
+// the Markdown document.
+func headingLinkRequests(doc *docs.Document, links []headingLink, end int64) ([]*docs.Request, error) {
+	var headingIDs []string
+	if doc.Body != nil {
+		for _, s := range doc.Body.Content {
+			if s.StartIndex >= end {
+				break
+			}
+			if s.Paragraph != nil && s.Paragraph.ParagraphStyle != nil && s.Paragraph.ParagraphStyle.HeadingId != "" {
+				headingIDs = append(headingIDs, s.Paragraph.ParagraphStyle.HeadingId)
+			}
+		}
+	}
+	var requests []*docs.Request
+	for _, e := range links {
+		if e.heading >= len(headingIDs) {
+			return nil, fmt.Errorf("heading %d is missing from the document", e.heading+1)
+		}
+		if e.end <= e.start {
+			continue
+		}
+		requests = append(requests, &docs.Request{
+			UpdateTextStyle: &docs.UpdateTextStyleRequest{
+				TextStyle: &docs.TextStyle{
+					Link: &docs.Link{HeadingId: headingIDs[e.heading]},
+				},
+				Range: &docs.Range{
+					StartIndex: e.start,
+					EndIndex:   e.end,
+				},
+				Fields: "link",
+			},
+		})
+	}
+	return requests, nil
+}
@@ -0,0 +1,160 @@
+package convert
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"google.golang.org/api/docs/v1"
+)
+
+const (
+	// DefaultPageBreakMarker is the Markdown page breaks are exported as.
+	DefaultPageBreakMarker = "<!-- pagebreak -->"
+	// DefaultSectionBreakMarker is the Markdown section breaks are exported
+	// as.
+	DefaultSectionBreakMarker = "<!-- sectionbreak -->"
+)
+
+// LineBreakStyle is a way of writing the line breaks within a paragraph,
+// which Docs stores as vertical tabs, as Markdown hard line breaks.
+type LineBreakStyle int
+
+const (
+	// LineBreaksBackslash ends broken lines with a backslash.
+	LineBreaksBackslash LineBreakStyle = iota
+	// LineBreaksSpaces ends broken lines with two spaces.
+	LineBreaksSpaces
+)
+
+// marker returns the Markdown ending a line before a hard line break.
+func (s LineBreakStyle) marker() string {
+	if s == LineBreaksSpaces {
+		return "  "
+	}
+	return `\`
+}
+
+// breakLines replaces the line breaks in a paragraph's Markdown with hard
+// line breaks, escaping the start of each line after the first and indenting
+// it by indent. Headings must be written on one line, so their line breaks
+// are replaced with spaces.
+func (mc *MarkdownConverter) breakLines(text string, heading bool, indent int) string {
+	if !strings.Contains(text, "\v") {
+		return text
+	}
+	if heading {
+		return strings.ReplaceAll(text, "\v", " ")
+	}
+	lines := strings.Split(text, "\v")
+	for i := 1; i < len(lines); i++ {
+		lines[i] = strings.Repeat(" ", indent) + escapeLineStart(strings.TrimLeftFunc(lines[i], unicode.IsSpace))
+	}
+	for i := 0; i < len(lines)-1; i++ {
+		lines[i] = strings.TrimRightFunc(lines[i], unicode.IsSpace)
+	}
+	return strings.Join(lines, mc.LineBreaks.marker()+"\n")
+}
+
+// breakKind is a kind of break between blocks.
+type breakKind int
+
+const (
+	noBreak breakKind = iota
+	horizontalRule
+	pageBreak
+	sectionBreak
+)
+
+// isHorizontalRule reports whether a paragraph is a horizontal rule: either a
+// Docs horizontal rule, or an empty paragraph with a bottom border as
+// MarkdownToDoc writes thematic breaks.
+func isHorizontalRule(p *docs.Paragraph) bool {
+	hasRule := false
+	for _, elem := range p.Elements {
+		switch {
+		case elem.HorizontalRule != nil:
+			hasRule = true
+		case elem.TextRun != nil:
+			if strings.TrimSpace(elem.TextRun.Content) != "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	if hasRule {
+		return true
+	}
+	border := p.ParagraphStyle.BorderBottom
+	return border != nil && border.Width != nil && border.Width.Magnitude > 0
+}
+
+// hasPageBreak reports whether a paragraph contains a page break.
+func hasPageBreak(p *docs.Paragraph) bool {
+	for _, elem := range p.Elements {
+		if elem.PageBreak != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// blockBreak returns the kind of break a top-level Markdown block stands for.
+// Page and section breaks are blocks consisting only of their marker.
+func (dc *DocConverter) blockBreak(n ast.Node, source []byte) breakKind {
+	if _, ok := n.Parent().(*ast.Document); !ok {
+		return noBreak
+	}
+	var lines []byte
+	switch n := n.(type) {
+	case *ast.ThematicBreak:
+		return horizontalRule
+	case *ast.HTMLBlock:
+		lines = blockLines(n, source)
+		if n.HasClosure() {
+			lines = append(lines, n.ClosureLine.Value(source)...)
+		}
+	case *ast.Paragraph:
+		lines = blockLines(n, source)
+	default:
+		return noBreak
+	}
+	switch marker := string(bytes.TrimSpace(lines)); {
+	case marker == "":
+		return noBreak
+	case marker == dc.PageBreakMarker:
+		return pageBreak
+	case marker == dc.SectionBreakMarker:
+		return sectionBreak
+	}
+	return noBreak
+}
+
+// blockLines returns the source text of a block.
+func blockLines(n ast.Node, source []byte) []byte {
+	var b []byte
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		b = append(b, line.Value(source)...)
+	}
+	return b
+}
+
+// horizontalRuleStyle is the paragraph style thematic breaks are written
+// with, as the Docs API cannot insert horizontal rules.
+func horizontalRuleStyle() *docs.ParagraphStyle {
+	return &docs.ParagraphStyle{
+		NamedStyleType: "NORMAL_TEXT",
+		BorderBottom: &docs.ParagraphBorder{
+			Color: &docs.OptionalColor{
+				Color: &docs.Color{
+					RgbColor: &docs.RgbColor{Red: 0.6, Green: 0.6, Blue: 0.6},
+				},
+			},
+			DashStyle: "SOLID",
+			Width:     &docs.Dimension{Magnitude: 1, Unit: "PT"},
+		},
+	}
+}
@@ -0,0 +1,124 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// ChipTemplates are the text/template templates smart chips are exported
+// with. Their output is written as Markdown, so templates escape text with
+// the markdown function and link destinations with the destination function.
+type ChipTemplates struct {
+	// Person is executed with the chip's *docs.PersonProperties.
+	Person string
+	// RichLink is executed with the chip's *docs.RichLinkProperties.
+	RichLink string
+	// Date is executed with the chip's DateChip.
+	Date string
+}
+
+// DefaultChipTemplates export people as mailto links, rich links as links
+// titled with the linked file's title, and dates as ISO 8601 dates.
+var DefaultChipTemplates = ChipTemplates{
+	Person:   `{{if .Email}}[{{markdown (or .Name .Email)}}]({{destination (print "mailto:" .Email)}}){{else}}@{{markdown .Name}}{{end}}`,
+	RichLink: `[{{markdown (or .Title .Uri)}}]({{destination .Uri}})`,
+	Date:     `{{.Time.Format "2006-01-02"}}`,
+}
+
+// DateChip is the data a date chip template is executed with.
+type DateChip struct {
+	// Time is the date, in the chip's time zone if it has one.
+	Time time.Time
+	// DisplayText is the date as shown in the document.
+	DisplayText string
+	// Properties are the chip's properties.
+	Properties *docs.DateElementProperties
+}
+
+// chipFuncs are the functions chip templates can call.
+var chipFuncs = template.FuncMap{
+	"markdown":    escapeMarkdown,
+	"destination": markdownDestination,
+}
+
+// chipTemplates are parsed ChipTemplates.
+type chipTemplates struct {
+	person, richLink, date *template.Template
+}
+
+// parse parses the templates, checking that each can be executed with its
+// chip's data.
+func (ct ChipTemplates) parse() (*chipTemplates, error) {
+	var parsed chipTemplates
+	for _, t := range []struct {
+		name string
+		text string
+		data any
+		dst  **template.Template
+	}{
+		{"person", ct.Person, &docs.PersonProperties{}, &parsed.person},
+		{"rich link", ct.RichLink, &docs.RichLinkProperties{}, &parsed.richLink},
+		{"date", ct.Date, DateChip{Properties: &docs.DateElementProperties{}}, &parsed.date},
+	} {
+		tmpl, err := template.New(t.name).Funcs(chipFuncs).Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s chip template: %w", t.name, err)
+		}
+		if err := tmpl.Execute(new(strings.Builder), t.data); err != nil {
+			return nil, fmt.Errorf("invalid %s chip template: %w", t.name, err)
+		}
+		*t.dst = tmpl
+	}
+	return &parsed, nil
+}
+
+// chipAsMarkdown returns the Markdown for a smart chip, or "" if elem is
+// not one.
+func (mc *MarkdownConverter) chipAsMarkdown(elem *docs.ParagraphElement) string {
+	var (
+		tmpl     *template.Template
+		data     any
+		fallback string
+	)
+	switch {
+	case elem.Person != nil && elem.Person.PersonProperties != nil:
+		props := elem.Person.PersonProperties
+		tmpl, data, fallback = mc.chips.person, props, escapeMarkdown(props.Name)
+	case elem.RichLink != nil && elem.RichLink.RichLinkProperties != nil:
+		props := elem.RichLink.RichLinkProperties
+		tmpl, data, fallback = mc.chips.richLink, props, escapeMarkdown(props.Uri)
+	case elem.DateElement != nil && elem.DateElement.DateElementProperties != nil:
+		props := elem.DateElement.DateElementProperties
+		chip, ok := dateChip(props)
+		if !ok {
+			return escapeMarkdown(props.DisplayText)
+		}
+		tmpl, data, fallback = mc.chips.date, chip, escapeMarkdown(props.DisplayText)
+	default:
+		return ""
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		// The templates were checked when parsed, so this is only reached
+		// for chips with unexpected data.
+		return fallback
+	}
+	return b.String()
+}
+
+// dateChip returns the template data for a date chip, or false if its
+// timestamp cannot be parsed.
+func dateChip(props *docs.DateElementProperties) (DateChip, bool) {
+	t, err := time.Parse(time.RFC3339, props.Timestamp)
+	if err != nil {
+		return DateChip{}, false
+	}
+	if loc, err := time.LoadLocation(props.TimeZoneId); props.TimeZoneId != "" && err == nil {
+		t = t.In(loc)
+	}
+	return DateChip{Time: t, DisplayText: props.DisplayText, Properties: props}, true
+}
@@ -0,0 +1,223 @@
+package convert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+)
+
+// CommentStyle is a strategy for exporting comments.
+type CommentStyle int
+
+const (
+	// CommentsOmitted leaves comments out.
+	CommentsOmitted CommentStyle = iota
+	// CommentsFootnotes exports comments as footnotes referenced after the
+	// text they quote.
+	CommentsFootnotes
+	// CommentsHTML exports comments as HTML comments after the text they
+	// quote.
+	CommentsHTML
+)
+
+// commentMarker is the Markdown marking a comment, written at an offset into
+// a text run.
+type commentMarker struct {
+	offset int
+	marker string
+}
+
+// anchorComments fetches the document's open comments and finds the text
+// runs their quoted text ends in, in any of the document's tabs if it was
+// retrieved with their content. Comments whose quoted text cannot be found
+// are kept to be written at the end of the document.
+func (mc *MarkdownConverter) anchorComments(doc *docs.Document) error {
+	mc.commentMarkers = map[*docs.TextRun][]commentMarker{}
+	mc.commentNotes = nil
+	mc.unanchoredComments = nil
+	bodies := []*docs.Body{doc.Body}
+	if hasTabContent(doc) {
+		bodies = nil
+		for _, tab := range DocumentTabs(doc) {
+			if tab.DocumentTab != nil {
+				bodies = append(bodies, tab.DocumentTab.Body)
+			}
+		}
+	}
+	var paragraphs []*docs.Paragraph
+	for _, body := range bodies {
+		if body != nil {
+			forEachParagraph(body.Content, func(p *docs.Paragraph) {
+				paragraphs = append(paragraphs, p)
+			})
+		}
+	}
+	if mc.CommentStyle == CommentsOmitted || mc.Comments == nil || len(paragraphs) == 0 {
+		return nil
+	}
+	comments, err := mc.Comments.ListComments(doc.DocumentId)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if c.Deleted || c.Resolved {
+			continue
+		}
+		text := commentText(c)
+		run, offset := findQuote(paragraphs, commentQuote(c))
+		if run == nil {
+			mc.unanchoredComments = append(mc.unanchoredComments, htmlComment(text))
+			continue
+		}
+		marker := htmlComment(text)
+		if mc.CommentStyle == CommentsFootnotes {
+			label := fmt.Sprintf("comment-%d", len(mc.commentNotes)+1)
+			marker = "[^" + label + "]"
+			mc.commentNotes = append(mc.commentNotes, footnoteDefinition(label, text))
+		}
+		mc.commentMarkers[run] = append(mc.commentMarkers[run], commentMarker{offset, marker})
+	}
+	for _, markers := range mc.commentMarkers {
+		sort.SliceStable(markers, func(i, j int) bool {
+			return markers[i].offset < markers[j].offset
+		})
+	}
+	return nil
+}
+
+// commentsAsMarkdown returns the footnote definitions of anchored comments
+// and the comments that could not be anchored.
+func (mc *MarkdownConverter) commentsAsMarkdown() []string {
+	var md []string
+	for _, note := range mc.commentNotes {
+		md = append(md, note...)
+	}
+	for _, c := range mc.unanchoredComments {
+		md = append(md, "", c)
+	}
+	return md
+}
+
+// splitAtComments splits a text run at the comment markers anchored in it,
+// returning the parts of the run and the markers written after each.
+func (mc *MarkdownConverter) splitAtComments(tr *docs.TextRun) ([]*docs.TextRun, [][]string) {
+	markers := mc.commentMarkers[tr]
+	if len(markers) == 0 {
+		return []*docs.TextRun{tr}, [][]string{nil}
+	}
+	var (
+		parts      []*docs.TextRun
+		partMarker [][]string
+		start      int
+	)
+	for _, m := range markers {
+		if m.offset > start || len(parts) == 0 {
+			part := *tr
+			part.Content = tr.Content[start:m.offset]
+			parts = append(parts, &part)
+			partMarker = append(partMarker, nil)
+			start = m.offset
+		}
+		partMarker[len(partMarker)-1] = append(partMarker[len(partMarker)-1], m.marker)
+	}
+	if start < len(tr.Content) {
+		part := *tr
+		part.Content = tr.Content[start:]
+		parts = append(parts, &part)
+		partMarker = append(partMarker, nil)
+	}
+	return parts, partMarker
+}
+
+// forEachParagraph calls f for each paragraph in content, including those in
+// tables.
+func forEachParagraph(content []*docs.StructuralElement, f func(*docs.Paragraph)) {
+	for _, s := range content {
+		switch {
+		case s.Paragraph != nil:
+			f(s.Paragraph)
+		case s.Table != nil:
+			for _, row := range s.Table.TableRows {
+				for _, cell := range row.TableCells {
+					forEachParagraph(cell.Content, f)
+				}
+			}
+		}
+	}
+}
+
+// commentQuote returns the first line of the text a comment quotes, as
+// quotes spanning paragraphs are anchored where they start.
+func commentQuote(c *drive.Comment) string {
+	if c.QuotedFileContent == nil {
+		return ""
+	}
+	quote, _, _ := strings.Cut(c.QuotedFileContent.Value, "\n")
+	return strings.TrimSpace(quote)
+}
+
+// findQuote returns the text run the first occurrence of quote ends in and
+// the offset into the run at which it ends.
+func findQuote(paragraphs []*docs.Paragraph, quote string) (*docs.TextRun, int) {
+	if quote == "" {
+		return nil, 0
+	}
+	for _, p := range paragraphs {
+		i := strings.Index(paragraphText(p), quote)
+		if i < 0 {
+			continue
+		}
+		end := i + len(quote)
+		pos := 0
+		for _, elem := range p.Elements {
+			if elem.TextRun == nil {
+				continue
+			}
+			n := len(elem.TextRun.Content)
+			if end <= pos+n {
+				return elem.TextRun, end - pos
+			}
+			pos += n
+		}
+	}
+	return nil, 0
+}
+
+// commentText returns a comment and its replies as a single line each,
+// prefixed with their authors.
+func commentText(c *drive.Comment) string {
+	lines := []string{authorName(c.Author) + ": " + oneLine(c.Content)}
+	for _, r := range c.Replies {
+		if r.Deleted || r.Content == "" {
+			continue
+		}
+		lines = append(lines, authorName(r.Author)+": "+oneLine(r.Content))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func authorName(u *drive.User) string {
+	if u == nil || u.DisplayName == "" {
+		return "Unknown"
+	}
+	return u.DisplayName
+}
+
+// oneLine joins the lines of s with spaces.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// htmlComment returns text as an HTML comment, on one line.
+func htmlComment(text string) string {
+	text = strings.ReplaceAll(text, "\n", " / ")
+	// Comments end at the first "--", and replacing one can leave another
+	// in an odd run of dashes.
+	for strings.Contains(text, "--") {
+		text = strings.ReplaceAll(text, "--", "- -")
+	}
+	return "<!-- " + text + " -->"
+}
@@ -0,0 +1,88 @@
+package convert
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeCommentService is a CommentService returning a fixed set of comments.
+type fakeCommentService struct {
+	comments []*drive.Comment
+	// calls counts the calls to ListComments.
+	calls int
+}
+
+var _ CommentService = (*fakeCommentService)(nil)
+
+func (f *fakeCommentService) ListComments(documentId string) ([]*drive.Comment, error) {
+	f.calls++
+	return f.comments, nil
+}
+
+func comment(author, content, quote string, replies ...*drive.Reply) *drive.Comment {
+	return &drive.Comment{
+		Author:            &drive.User{DisplayName: author},
+		Content:           content,
+		QuotedFileContent: &drive.CommentQuotedFileContent{Value: quote},
+		Replies:           replies,
+	}
+}
+
+func TestAsMarkdownComments(t *testing.T) {
+	doc := &docs.Document{
+		Title: "Doc",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun("Ship the "), textRun("new parser today\n")),
+		}},
+	}
+	resolved := comment("Bo", "Done", "Ship")
+	resolved.Resolved = true
+	comments := &fakeCommentService{comments: []*drive.Comment{
+		comment("Ada", "Which one?", "the new parser", &drive.Reply{Author: &drive.User{DisplayName: "Bo"}, Content: "The\nfast one"}),
+		comment("Cy", "Typo ---> fix", "Ship"),
+		comment("Di", "Gone", "deleted text"),
+		resolved,
+	}}
+	tests := []struct {
+		name  string
+		style CommentStyle
+		want  string
+	}{
+		{
+			name:  "omitted",
+			style: CommentsOmitted,
+			want:  "# Doc\nShip the new parser today\n\n",
+		},
+		{
+			name:  "footnotes",
+			style: CommentsFootnotes,
+			want: "# Doc\nShip[^comment-2] the new parser[^comment-1] today\n\n\n" +
+				"\n[^comment-1]: Ada: Which one?\n\n    Bo: The fast one" +
+				"\n\n[^comment-2]: Cy: Typo ---> fix" +
+				"\n\n<!-- Di: Gone -->",
+		},
+		{
+			name:  "html",
+			style: CommentsHTML,
+			want: "# Doc\nShip<!-- Cy: Typo - - -> fix --> the new parser<!-- Ada: Which one? / Bo: The fast one --> today\n\n\n" +
+				"\n<!-- Di: Gone -->",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMarkdownConverter()
+			mc.Comments = comments
+			mc.CommentStyle = tt.style
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/docs/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the YAML front matter at the start of a Markdown document.
+type FrontMatter struct {
+	// DocumentID is the ID of the Google Doc the Markdown is synced with.
+	DocumentID string `yaml:"document_id,omitempty"`
+	// TabID is the ID of the tab of the document the Markdown is synced
+	// with, if it is synced with a single tab.
+	TabID string `yaml:"tab_id,omitempty"`
+	// Title is the title of the document.
+	Title string `yaml:"title,omitempty"`
+	// RevisionID is the revision of the document that was exported.
+	RevisionID string `yaml:"revision_id,omitempty"`
+	// LastSynced is when the document was exported.
+	LastSynced time.Time `yaml:"last_synced,omitempty"`
+	// SourceURL is the URL the document can be edited at.
+	SourceURL string `yaml:"source_url,omitempty"`
+	// Header is the text of the document's default header.
+	Header string `yaml:"header,omitempty"`
+	// Footer is the text of the document's default footer.
+	Footer string `yaml:"footer,omitempty"`
+	// Styles override the options the Markdown is written to the document
+	// with.
+	Styles *StyleOverrides `yaml:"styles,omitempty"`
+}
+
+// StyleOverrides are DocConverter options set from front matter.
+type StyleOverrides struct {
+	// CodeFont overrides DocConverter.CodeFont.
+	CodeFont string `yaml:"code_font,omitempty"`
+	// CodeBackground overrides DocConverter.CodeBackground with a hex color
+	// such as "#f3f3f3".
+	CodeBackground string `yaml:"code_background,omitempty"`
+	// LinkBaseURL overrides DocConverter.LinkBaseURL.
+	LinkBaseURL string `yaml:"link_base_url,omitempty"`
+}
+
+// documentURL returns the URL a Google Doc can be edited at.
+func documentURL(documentID string) string {
+	return "https://docs.google.com/document/d/" + documentID + "/edit"
+}
+
+// parseHexColor parses a color written as "#rrggbb".
+func parseHexColor(s string) (*docs.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return &docs.Color{
+		RgbColor: &docs.RgbColor{
+			Red:   float64(v>>16&0xff) / 255,
+			Green: float64(v>>8&0xff) / 255,
+			Blue:  float64(v&0xff) / 255,
+		},
+	}, nil
+}
+
+var frontMatterDelimiter = []byte("---")
+
+// SplitFrontMatter separates the YAML front matter delimited by "---" lines
+// at the start of md from the Markdown that follows it. If md has no front
+// matter, md is returned unchanged with an empty FrontMatter.
+func SplitFrontMatter(md []byte) (FrontMatter, []byte, error) {
+	var fm FrontMatter
+	rest, ok := cutLine(md, frontMatterDelimiter)
+	if !ok {
+		return fm, md, nil
+	}
+	var yamlContent []byte
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		if bytes.Equal(bytes.TrimRight(line, "\r\n"), frontMatterDelimiter) {
+			if err := yaml.Unmarshal(yamlContent, &fm); err != nil {
+				return fm, md, fmt.Errorf("invalid front matter: %w", err)
+			}
+			return fm, rest, nil
+		}
+		yamlContent = append(yamlContent, line...)
+	}
+	// Without a closing delimiter the "---" is a thematic break.
+	return FrontMatter{}, md, nil
+}
+
+// cutLine returns the text after the first line of b if that line is line.
+func cutLine(b, line []byte) ([]byte, bool) {
+	first, rest, _ := bytes.Cut(b, []byte("\n"))
+	if !bytes.Equal(bytes.TrimRight(first, "\r"), line) {
+		return nil, false
+	}
+	return rest, true
+}
+
+// Markdown returns the front matter as Markdown lines, or nil if it is empty.
+func (fm FrontMatter) Markdown() ([]string, error) {
+	if fm == (FrontMatter{}) {
+		return nil, nil
+	}
+	b, err := yaml.Marshal(fm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal front matter: %w", err)
+	}
+	return []string{"---", string(bytes.TrimRight(b, "\n")), "---"}, nil
+}
@@ -0,0 +1,94 @@
+package convert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		md       string
+		want     FrontMatter
+		wantRest string
+		wantErr  bool
+	}{
+		{
+			name:     "none",
+			md:       "# Title\n",
+			wantRest: "# Title\n",
+		},
+		{
+			name:     "header and footer",
+			md:       "---\nheader: Draft\nfooter: |\n  Page\n  Footer\n---\n# Title\n",
+			want:     FrontMatter{Header: "Draft", Footer: "Page\nFooter\n"},
+			wantRest: "# Title\n",
+		},
+		{
+			name:     "crlf",
+			md:       "---\r\nheader: Draft\r\n---\r\nBody",
+			want:     FrontMatter{Header: "Draft"},
+			wantRest: "Body",
+		},
+		{
+			name:     "unterminated",
+			md:       "---\nheader: Draft\n",
+			wantRest: "---\nheader: Draft\n",
+		},
+		{
+			name:    "invalid",
+			md:      "---\nheader: [\n---\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, rest, err := SplitFrontMatter([]byte(tt.md))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitFrontMatter error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, fm); diff != "" {
+				t.Errorf("front matter mismatch (-want +got):\n%s", diff)
+			}
+			if string(rest) != tt.wantRest {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestFrontMatterMarkdownRoundtrip(t *testing.T) {
+	fm := FrontMatter{
+		DocumentID: "abc123",
+		Title:      "Design: v2",
+		RevisionID: "rev7",
+		LastSynced: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		SourceURL:  documentURL("abc123"),
+		Header:     "Confidential",
+		Footer:     "Line one\nLine two",
+		Styles:     &StyleOverrides{CodeFont: "Courier New", CodeBackground: "#eeeeee"},
+	}
+	lines, err := fm.Markdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := ""
+	for _, line := range lines {
+		md += line + "\n"
+	}
+	got, rest, err := SplitFrontMatter([]byte(md + "Body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(fm, got); diff != "" {
+		t.Errorf("front matter mismatch (-want +got):\n%s", diff)
+	}
+	if string(rest) != "Body" {
+		t.Errorf("rest = %q, want %q", rest, "Body")
+	}
+}
@@ -1,14 +1,181 @@
 package convert
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
 	"google.golang.org/api/docs/v1"
 )
 
 type MarkdownConverter struct {
 	StylesToPrefix map[string]string
+	// ListIndent is the number of spaces each nesting level of a list is
+	// indented by. Nested items are always indented at least as far as the
+	// content of their parent item, and at most three spaces past it, so
+	// that they parse as sub-items.
+	ListIndent int
+	// MonospaceFonts are the font families treated as code. Paragraphs
+	// written entirely in one of them are exported as fenced code blocks.
+	MonospaceFonts []string
+	// InferCodeLanguage adds a language tag guessed from the content to
+	// fenced code blocks.
+	InferCodeLanguage bool
+	// TableFormat selects how tables are exported.
+	TableFormat TableFormat
+	// AssetsDir is the directory images are downloaded into. Images link to
+	// their downloaded copy, or to the document's short-lived image URL if
+	// AssetsDir is empty.
+	AssetsDir string
+	// MarkdownDir is the directory the Markdown is written to. Downloaded
+	// images are linked to relative to it, as Markdown image paths are
+	// resolved relative to the file.
+	MarkdownDir string
+	// HTTPClient is the client images are downloaded with. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+	// HeadersFooters selects how the document's default header and footer
+	// are exported.
+	HeadersFooters HeaderFooterMode
+	// FrontMatter replaces the title heading with front matter describing
+	// the document.
+	FrontMatter bool
+	// PageBreakMarker is the block page breaks are exported as. Page breaks
+	// are dropped if it is empty.
+	PageBreakMarker string
+	// SectionBreakMarker is the block section breaks are exported as.
+	// Section breaks are dropped if it is empty.
+	SectionBreakMarker string
+	// ChipTemplates are the templates smart chips are exported with.
+	ChipTemplates ChipTemplates
+	// Comments lists the document's comments. Comments are only exported
+	// if it is set.
+	Comments CommentService
+	// CommentStyle selects how open comments are exported.
+	CommentStyle CommentStyle
+	// Suggestions selects how pending suggestions are exported.
+	Suggestions SuggestionsMode
+	// CriticMarkup marks suggested insertions and deletions with
+	// CriticMarkup. Otherwise suggestions are exported as they appear in
+	// the document.
+	CriticMarkup bool
+	// LineBreaks selects how line breaks within paragraphs are exported.
+	LineBreaks LineBreakStyle
+
+	// now returns the time the document is exported at.
+	now func() time.Time
+
+	doc *docs.Document
+	// tab is the tab being exported, if the document has tabs.
+	tab *docs.TabProperties
+	// tabID is the ID of the tab whose content is being exported, if the
+	// document was retrieved with its tabs' content.
+	tabID string
+	// tabSections is set while a document's tabs are exported as sections
+	// of one file. Comments are then anchored, and footnotes and heading
+	// anchors numbered, across all the tabs.
+	tabSections bool
+	// footnoteBase is the number of footnotes in the sections exported
+	// before the current one.
+	footnoteBase int
+	// listCounters counts the items seen so far at each nesting level of
+	// each list, keyed by list ID.
+	listCounters map[string][]int64
+	// lastListID is the list ID of the previous paragraph, if it was a list
+	// item.
+	lastListID string
+	// footnotes are the footnotes referenced so far, in order.
+	footnotes []*docs.FootnoteReference
+	// anchors maps Docs headings to the anchors of their Markdown
+	// headings.
+	anchors map[headingKey]string
+	// commentMarkers are the comment markers to write in each text run.
+	commentMarkers map[*docs.TextRun][]commentMarker
+	// commentNotes are the footnote definitions of comments.
+	commentNotes [][]string
+	// unanchoredComments are the comments whose quoted text was not found.
+	unanchoredComments []string
+	// chips are the parsed ChipTemplates.
+	chips *chipTemplates
+	// images maps inline object IDs to the path of their downloaded image.
+	images map[string]string
+	// listColumns holds the marker and content columns of the most recent
+	// item at each nesting level of the current list.
+	listColumns []listColumn
+}
+
+type listColumn struct {
+	marker, content int
+}
+
+// TableFormat is a strategy for exporting tables.
+type TableFormat int
+
+const (
+	// TableAuto exports GFM tables, falling back to HTML tables for tables
+	// with merged cells or lists, which GFM cannot express.
+	TableAuto TableFormat = iota
+	// TableGFM always exports GFM tables, leaving merged cells split.
+	TableGFM
+	// TableHTML always exports HTML tables.
+	TableHTML
+)
+
+// HeaderFooterMode is a strategy for exporting headers and footers.
+type HeaderFooterMode int
+
+const (
+	// HeadersFootersOmitted leaves headers and footers out.
+	HeadersFootersOmitted HeaderFooterMode = iota
+	// HeadersFootersFrontMatter exports headers and footers as front matter.
+	HeadersFootersFrontMatter
+	// HeadersFootersSections exports the header before the body and the
+	// footer after it, each between HTML comments naming the section.
+	HeadersFootersSections
+)
+
+// SuggestionsMode is a strategy for exporting pending suggestions.
+type SuggestionsMode int
+
+const (
+	// SuggestionsInline exports suggested insertions and deletions alongside
+	// the rest of the text.
+	SuggestionsInline SuggestionsMode = iota
+	// SuggestionsAccepted exports the document as if all suggestions were
+	// accepted.
+	SuggestionsAccepted
+	// SuggestionsRejected exports the document as if all suggestions were
+	// rejected.
+	SuggestionsRejected
+)
+
+// ViewMode returns the Docs suggestions view mode to retrieve a document
+// with for export in this mode.
+func (m SuggestionsMode) ViewMode() string {
+	switch m {
+	case SuggestionsAccepted:
+		return "PREVIEW_SUGGESTIONS_ACCEPTED"
+	case SuggestionsRejected:
+		return "PREVIEW_WITHOUT_SUGGESTIONS"
+	}
+	return "SUGGESTIONS_INLINE"
+}
+
+// isHiddenSuggestion reports whether a text run is a suggestion that is not
+// exported in the converter's suggestions mode. Documents retrieved with the
+// mode's view mode have no such runs.
+func (mc *MarkdownConverter) isHiddenSuggestion(tr *docs.TextRun) bool {
+	switch mc.Suggestions {
+	case SuggestionsAccepted:
+		return len(tr.SuggestedDeletionIds) > 0
+	case SuggestionsRejected:
+		return len(tr.SuggestedInsertionIds) > 0
+	}
+	return false
 }
 
 func NewMarkdownConverter() *MarkdownConverter {
@@ -24,58 +191,650 @@ func NewMarkdownConverter() *MarkdownConverter {
 			"HEADING_5":   "##### ",
 			"HEADING_6":   "###### ",
 		},
+		ListIndent:         4,
+		PageBreakMarker:    DefaultPageBreakMarker,
+		SectionBreakMarker: DefaultSectionBreakMarker,
+		ChipTemplates:      DefaultChipTemplates,
+		now:                time.Now,
+		MonospaceFonts: []string{
+			"Consolas",
+			"Courier",
+			"Courier New",
+			"Fira Code",
+			"Fira Mono",
+			"Inconsolata",
+			"JetBrains Mono",
+			"Roboto Mono",
+			"Source Code Pro",
+			"Space Mono",
+			"Ubuntu Mono",
+		},
 	}
 }
 
 func (mc *MarkdownConverter) AsMarkdown(doc *docs.Document) ([]byte, error) {
+	if hasTabContent(doc) {
+		if len(DocumentTabs(doc)) > 1 {
+			return mc.TabsAsMarkdown(doc)
+		}
+		if props := doc.Tabs[0].TabProperties; props != nil {
+			mc.tabID = props.TabId
+			defer func() { mc.tabID = "" }()
+		}
+		doc = TabDocument(doc, doc.Tabs[0])
+	}
+	mc.doc = doc
+	mc.listCounters = map[string][]int64{}
+	mc.lastListID = ""
+	mc.listColumns = nil
+	mc.footnotes = nil
+	chips, err := mc.ChipTemplates.parse()
+	if err != nil {
+		return nil, err
+	}
+	mc.chips = chips
+	title, titleLevel := mc.title(doc)
+	if !mc.tabSections {
+		if err := mc.anchorComments(doc); err != nil {
+			return nil, err
+		}
+		mc.images = map[string]string{}
+		if err := mc.downloadImages(doc); err != nil {
+			return nil, err
+		}
+		// Anchors are generated from the Markdown of headings, so they
+		// are found once everything a heading's Markdown depends on is
+		// known.
+		mc.anchors = map[headingKey]string{}
+		mc.addHeadingAnchors(parser.NewContext().IDs(), doc, title, titleLevel > 0)
+	}
+
 	var md []string
-	md = append(md, fmt.Sprintf("# %s", doc.Title))
+	header, footer := mc.headerFooterText(doc)
+	var fm FrontMatter
+	if mc.FrontMatter {
+		fm = mc.documentFrontMatter(doc)
+	}
+	if mc.HeadersFooters == HeadersFootersFrontMatter {
+		fm.Header, fm.Footer = header, footer
+	}
+	fmMd, err := fm.Markdown()
+	if err != nil {
+		return nil, err
+	}
+	md = append(md, fmMd...)
+	if titleLevel > 0 {
+		md = append(md, fmt.Sprintf("%s %s", strings.Repeat("#", titleLevel), title))
+	}
+	if mc.HeadersFooters == HeadersFootersSections && header != "" {
+		md = append(md, "<!-- header -->", header, "<!-- /header -->", "")
+	}
 	if doc.Body != nil {
-		for _, s := range doc.Body.Content {
-			if s.Paragraph != nil {
+		content := doc.Body.Content
+		for i := 0; i < len(content); i++ {
+			s := content[i]
+			if n := mc.codeBlockLength(content[i:]); n > 0 {
+				md = append(md, mc.codeBlockAsMarkdown(content[i:i+n])...)
+				i += n - 1
+			} else if s.Paragraph != nil {
 				md = append(md, mc.paragraphAsMarkdown(s.Paragraph)...)
 			} else if s.Table != nil {
+				mc.endList()
 				md = append(md, mc.tableAsMarkdown(s.Table)...)
+			} else if s.TableOfContents != nil {
+				mc.endList()
+				md = append(md, mc.tableOfContentsAsMarkdown(s.TableOfContents)...)
+			} else if s.SectionBreak != nil && i > 0 && mc.SectionBreakMarker != "" {
+				// Every document starts with a section break, which is
+				// implied.
+				mc.endList()
+				md = append(md, mc.SectionBreakMarker, "\n")
 			}
 		}
 	}
+	md = append(md, mc.footnotesAsMarkdown()...)
+	if !mc.tabSections {
+		md = append(md, mc.commentsAsMarkdown()...)
+	}
+	if mc.HeadersFooters == HeadersFootersSections && footer != "" {
+		md = append(md, "", "<!-- footer -->", footer, "<!-- /footer -->")
+	}
 	return []byte(strings.Join(md, "\n")), nil
 }
 
+// title returns the title the document is exported under and the level of
+// its heading, or a level of 0 if the title is only written in front matter.
+// Tabs are exported under their own titles.
+func (mc *MarkdownConverter) title(doc *docs.Document) (string, int) {
+	if mc.tab != nil {
+		return mc.tab.Title, 1 + int(mc.tab.NestingLevel)
+	}
+	if mc.FrontMatter {
+		return doc.Title, 0
+	}
+	return doc.Title, 1
+}
+
+// documentFrontMatter returns the front matter describing the document.
+func (mc *MarkdownConverter) documentFrontMatter(doc *docs.Document) FrontMatter {
+	fm := FrontMatter{
+		DocumentID: doc.DocumentId,
+		Title:      doc.Title,
+		RevisionID: doc.RevisionId,
+		SourceURL:  documentURL(doc.DocumentId),
+	}
+	if mc.tab != nil && mc.tab.TabId != "" {
+		fm.TabID = mc.tab.TabId
+		fm.SourceURL += "?tab=" + mc.tab.TabId
+	}
+	if mc.now != nil {
+		fm.LastSynced = mc.now().UTC().Truncate(time.Second)
+	}
+	return fm
+}
+
+// headerFooterText returns the text of the document's default header and
+// footer, if they are to be exported. Headers and footers exported as
+// sections are Markdown, while those in front matter are plain text, as that
+// is how they are written back into the document.
+func (mc *MarkdownConverter) headerFooterText(doc *docs.Document) (header, footer string) {
+	if mc.HeadersFooters == HeadersFootersOmitted || doc.DocumentStyle == nil {
+		return "", ""
+	}
+	segment := mc.segmentMarkdown
+	if mc.HeadersFooters == HeadersFootersFrontMatter {
+		segment = mc.segmentText
+	}
+	if h, ok := doc.Headers[doc.DocumentStyle.DefaultHeaderId]; ok {
+		header = segment(h.Content)
+	}
+	if f, ok := doc.Footers[doc.DocumentStyle.DefaultFooterId]; ok {
+		footer = segment(f.Content)
+	}
+	return header, footer
+}
+
+// segmentMarkdown returns the Markdown of a segment's content with the blank
+// lines between its paragraphs removed.
+func (mc *MarkdownConverter) segmentMarkdown(content []*docs.StructuralElement) string {
+	var lines []string
+	for _, line := range mc.contentMarkdown(content) {
+		if line != "" && line != "\n" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// segmentText returns the text of a segment's paragraphs, one per line, as
+// their Markdown reads once rendered, so that chips are written as they are
+// exported.
+func (mc *MarkdownConverter) segmentText(content []*docs.StructuralElement) string {
+	var lines []string
+	for _, s := range content {
+		if s.Paragraph == nil {
+			continue
+		}
+		md := []byte(escapeLineStart(mc.elementsAsMarkdown(s.Paragraph.Elements, false)))
+		if line := plainText(NewMarkdownParser().Parse(text.NewReader(md)), md); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// footnotesAsMarkdown returns the definitions of the footnotes referenced in
+// the document.
+func (mc *MarkdownConverter) footnotesAsMarkdown() []string {
+	var md []string
+	for _, ref := range mc.footnotes {
+		footnote, ok := mc.doc.Footnotes[ref.FootnoteId]
+		if !ok {
+			continue
+		}
+		md = append(md, footnoteDefinition(ref.FootnoteNumber, mc.segmentMarkdown(footnote.Content))...)
+	}
+	return md
+}
+
 func (mc *MarkdownConverter) paragraphAsMarkdown(p *docs.Paragraph) []string {
 	var md []string
 	prefix := mc.StylesToPrefix[p.ParagraphStyle.NamedStyleType]
-	isList := p.Bullet != nil
+	var runs []*docs.TextRun
 	for _, elem := range p.Elements {
 		if elem.TextRun != nil {
-			text := processTextRuns(elem.TextRun)
-			if isList && len(text) > 0 {
-				text = "* " + text
-			}
-			md = append(md, prefix+text)
+			runs = append(runs, elem.TextRun)
 		}
 	}
+	checked := false
+	if p.Bullet != nil && isCheckboxLevel(mc.nestingLevel(p.Bullet)) {
+		// Docs strikes through the text of checked items.
+		checked = isStruckThrough(runs)
+	}
+	text := escapeLineStart(mc.elementsAsMarkdown(p.Elements, checked))
 	if p.Bullet == nil {
-		md = append(md, "\n")
+		text = mc.breakLines(text, strings.HasPrefix(prefix, "#"), 0)
+		if mc.lastListID != "" {
+			// Separate the paragraph from the list so it is not parsed as a
+			// continuation of the last item.
+			md = append(md, "")
+		}
+		mc.endList()
+		if isHorizontalRule(p) {
+			return append(md, "---", "\n")
+		}
+		if hasPageBreak(p) && mc.PageBreakMarker != "" {
+			if text != "" {
+				md = append(md, prefix+text, "")
+			}
+			return append(md, mc.PageBreakMarker, "\n")
+		}
+		return append(md, prefix+text, "\n")
+	}
+	if len(text) == 0 {
+		return append(md, prefix+text)
+	}
+	if mc.lastListID != "" && mc.lastListID != p.Bullet.ListId && p.Bullet.NestingLevel == 0 {
+		// Adjacent lists would otherwise be merged into one by Markdown
+		// parsers, losing the numbering restart.
+		md = append(md, "", "<!-- -->")
+	}
+	mc.lastListID = p.Bullet.ListId
+	itemPrefix := mc.listItemPrefix(p.Bullet, checked)
+	// Broken lines are indented to the item's content.
+	text = mc.breakLines(text, strings.HasPrefix(prefix, "#"), len(itemPrefix))
+	return append(md, itemPrefix+prefix+text)
+}
+
+// elementsAsMarkdown returns the Markdown for the elements of a paragraph,
+// optionally removing strikethrough from its text.
+func (mc *MarkdownConverter) elementsAsMarkdown(elems []*docs.ParagraphElement, stripStrikethrough bool) string {
+	var md []string
+	var runs []*docs.TextRun
+	flush := func() {
+		if len(runs) == 0 {
+			return
+		}
+		if stripStrikethrough {
+			runs = withoutStrikethrough(runs)
+		}
+		md = append(md, mc.processTextRuns(runs...))
+		runs = nil
+	}
+	for _, elem := range elems {
+		switch {
+		case elem.TextRun != nil:
+			if mc.isHiddenSuggestion(elem.TextRun) {
+				continue
+			}
+			parts, markers := mc.splitAtComments(elem.TextRun)
+			for i, part := range parts {
+				runs = append(runs, part)
+				if len(markers[i]) > 0 {
+					flush()
+					md = append(md, markers[i]...)
+				}
+			}
+		case elem.InlineObjectElement != nil:
+			flush()
+			md = append(md, mc.inlineObjectAsMarkdown(elem.InlineObjectElement))
+		case elem.FootnoteReference != nil:
+			flush()
+			ref := elem.FootnoteReference
+			if ref.FootnoteNumber == "" || mc.tabSections {
+				// Number footnotes by position if Docs has not, and
+				// across sections when tabs are exported together, as
+				// Docs numbers each tab's footnotes from 1.
+				c := *ref
+				c.FootnoteNumber = fmt.Sprint(mc.footnoteBase + len(mc.footnotes) + 1)
+				ref = &c
+			}
+			mc.footnotes = append(mc.footnotes, ref)
+			md = append(md, "[^"+ref.FootnoteNumber+"]")
+		case elem.Person != nil, elem.RichLink != nil, elem.DateElement != nil:
+			flush()
+			md = append(md, mc.chipAsMarkdown(elem))
+		}
+	}
+	flush()
+	// Whitespace at the ends of a paragraph, such as its final newline, is
+	// not part of its Markdown.
+	return strings.TrimSpace(strings.Join(md, ""))
+}
+
+// footnoteDefinition returns a footnote definition with each line of text
+// as a paragraph, except for lines following a hard line break.
+func footnoteDefinition(label, text string) []string {
+	lines := strings.Split(text, "\n")
+	md := []string{"", "[^" + label + "]: " + lines[0]}
+	for i, line := range lines[1:] {
+		// Later lines are indented to continue the definition.
+		prev := lines[i]
+		if !strings.HasSuffix(prev, `\`) && !strings.HasSuffix(prev, "  ") {
+			md = append(md, "")
+		}
+		md = append(md, "    "+line)
 	}
 	return md
 }
 
+// endList resets the list state once a paragraph that is not a list item
+// is reached.
+func (mc *MarkdownConverter) endList() {
+	mc.lastListID = ""
+	mc.listColumns = nil
+}
+
+// listItemPrefix returns the indentation and marker for a list item at the
+// bullet's nesting level.
+func (mc *MarkdownConverter) listItemPrefix(b *docs.Bullet, checked bool) string {
+	n := int(b.NestingLevel)
+	// Levels skipped over in the document are indented as if they had an
+	// item without a marker. Their content is their parent's, as that is
+	// the item deeper levels are sub-items of.
+	for len(mc.listColumns) < n {
+		level := len(mc.listColumns)
+		content := 0
+		if level > 0 {
+			content = mc.listColumns[level-1].content
+		}
+		mc.listColumns = append(mc.listColumns, listColumn{mc.listIndent(level), content})
+	}
+	mc.listColumns = mc.listColumns[:n]
+	indent := mc.listIndent(n)
+	marker, width := mc.listMarker(b, checked)
+	mc.listColumns = append(mc.listColumns, listColumn{indent, indent + width})
+	return strings.Repeat(" ", indent) + marker
+}
+
+// listIndent returns the column at which the marker of an item at the given
+// nesting level starts. It is between the content column of the parent item
+// and three columns past it, as further indented markers continue the
+// parent's paragraph rather than starting a sub-list.
+func (mc *MarkdownConverter) listIndent(level int) int {
+	if level == 0 {
+		return 0
+	}
+	parent := mc.listColumns[level-1]
+	indent := parent.marker + mc.ListIndent
+	if indent < parent.content {
+		indent = parent.content
+	}
+	if indent > parent.content+3 {
+		indent = parent.content + 3
+	}
+	return indent
+}
+
+// orderedGlyphTypes are the glyph types used by numbered lists. Markdown only
+// has decimal numbering, so alphabetic and roman numbering are exported as
+// their position in the list.
+var orderedGlyphTypes = map[string]bool{
+	"DECIMAL":      true,
+	"ZERO_DECIMAL": true,
+	"UPPER_ALPHA":  true,
+	"ALPHA":        true,
+	"UPPER_ROMAN":  true,
+	"ROMAN":        true,
+}
+
+// nestingLevel returns the list properties for the bullet's nesting level, or
+// nil if the document does not describe the list.
+func (mc *MarkdownConverter) nestingLevel(b *docs.Bullet) *docs.NestingLevel {
+	if mc.doc == nil {
+		return nil
+	}
+	list, ok := mc.doc.Lists[b.ListId]
+	if !ok || list.ListProperties == nil {
+		return nil
+	}
+	levels := list.ListProperties.NestingLevels
+	if b.NestingLevel >= int64(len(levels)) {
+		return nil
+	}
+	return levels[b.NestingLevel]
+}
+
+// isCheckboxLevel reports whether a nesting level belongs to a checklist.
+// Checklists are the only lists whose levels have neither a glyph type nor a
+// glyph symbol.
+func isCheckboxLevel(level *docs.NestingLevel) bool {
+	if level == nil || level.GlyphSymbol != "" {
+		return false
+	}
+	return level.GlyphType == "" || level.GlyphType == "GLYPH_TYPE_UNSPECIFIED"
+}
+
+// isStruckThrough reports whether all the non-blank text of runs is struck
+// through.
+func isStruckThrough(runs []*docs.TextRun) bool {
+	struck := false
+	for _, tr := range runs {
+		if strings.TrimSpace(tr.Content) == "" {
+			continue
+		}
+		if tr.TextStyle == nil || !tr.TextStyle.Strikethrough {
+			return false
+		}
+		struck = true
+	}
+	return struck
+}
+
+// withoutStrikethrough returns copies of runs with strikethrough removed.
+func withoutStrikethrough(runs []*docs.TextRun) []*docs.TextRun {
+	var out []*docs.TextRun
+	for _, tr := range runs {
+		c := *tr
+		if c.TextStyle != nil {
+			style := *c.TextStyle
+			style.Strikethrough = false
+			c.TextStyle = &style
+		}
+		out = append(out, &c)
+	}
+	return out
+}
+
+// listMarker returns the list item marker for a bulleted paragraph, numbering
+// items of ordered lists, along with the width of the marker that precedes
+// the item's content.
+func (mc *MarkdownConverter) listMarker(b *docs.Bullet, checked bool) (string, int) {
+	n := int(b.NestingLevel)
+	counters := mc.listCounters[b.ListId]
+	for len(counters) <= n {
+		counters = append(counters, 0)
+	}
+	// An item restarts the numbering of all deeper levels.
+	counters = counters[:n+1]
+	counters[n]++
+	mc.listCounters[b.ListId] = counters
+
+	level := mc.nestingLevel(b)
+	if isCheckboxLevel(level) {
+		// The task list checkbox is part of the item's content.
+		if checked {
+			return "- [x] ", 2
+		}
+		return "- [ ] ", 2
+	}
+	if level == nil || !orderedGlyphTypes[level.GlyphType] {
+		return "* ", 2
+	}
+	start := level.StartNumber
+	if start == 0 {
+		start = 1
+	}
+	delim := "."
+	if strings.HasSuffix(level.GlyphFormat, ")") {
+		delim = ")"
+	}
+	marker := fmt.Sprintf("%d%s ", start+counters[n]-1, delim)
+	return marker, len(marker)
+}
+
+// isMonospace reports whether text in the given style is set in one of the
+// converter's monospace fonts.
+func (mc *MarkdownConverter) isMonospace(style *docs.TextStyle) bool {
+	if style == nil || style.WeightedFontFamily == nil {
+		return false
+	}
+	for _, font := range mc.MonospaceFonts {
+		if strings.EqualFold(font, style.WeightedFontFamily.FontFamily) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCodeParagraph reports whether s is a normal text paragraph consisting
+// only of text in a monospace font.
+func (mc *MarkdownConverter) isCodeParagraph(s *docs.StructuralElement) bool {
+	p := s.Paragraph
+	if p == nil || p.Bullet != nil || len(p.Elements) == 0 {
+		return false
+	}
+	// Headings and other styled paragraphs keep their style, with their
+	// text as a code span.
+	if p.ParagraphStyle == nil || p.ParagraphStyle.NamedStyleType != "NORMAL_TEXT" {
+		return false
+	}
+	for _, elem := range p.Elements {
+		if elem.TextRun == nil || !mc.isMonospace(elem.TextRun.TextStyle) {
+			return false
+		}
+	}
+	return true
+}
+
+// codeBlockLength returns the number of paragraphs at the start of content
+// that form a code block. Blank paragraphs are only part of a code block if
+// they are followed by more code.
+func (mc *MarkdownConverter) codeBlockLength(content []*docs.StructuralElement) int {
+	n := 0
+	for i, s := range content {
+		if !mc.isCodeParagraph(s) {
+			break
+		}
+		if strings.TrimSpace(paragraphText(s.Paragraph)) != "" {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// paragraphText returns the text of a paragraph's text runs, without the
+// trailing newline.
+func paragraphText(p *docs.Paragraph) string {
+	var text string
+	for _, elem := range p.Elements {
+		if elem.TextRun != nil {
+			text += elem.TextRun.Content
+		}
+	}
+	return strings.TrimSuffix(text, "\n")
+}
+
+func (mc *MarkdownConverter) codeBlockAsMarkdown(content []*docs.StructuralElement) []string {
+	var md []string
+	if mc.lastListID != "" {
+		md = append(md, "")
+	}
+	mc.endList()
+	var lines []string
+	for _, s := range content {
+		// Line breaks within a paragraph are lines of code too.
+		lines = append(lines, strings.ReplaceAll(paragraphText(s.Paragraph), "\v", "\n"))
+	}
+	code := strings.Join(lines, "\n")
+	fence := codeFence(code)
+	info := ""
+	if mc.InferCodeLanguage {
+		info = inferCodeLanguage(code)
+	}
+	md = append(md, fence+info)
+	md = append(md, lines...)
+	return append(md, fence, "\n")
+}
+
+// codeFence returns a backtick fence longer than any run of backticks in code.
+func codeFence(code string) string {
+	longest := longestRun(code, '`')
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// inferCodeLanguage guesses the language of a code block from telltale
+// constructs, returning "" if it cannot tell.
+func inferCodeLanguage(code string) string {
+	trimmed := strings.TrimSpace(code)
+	firstLine := strings.SplitN(trimmed, "\n", 2)[0]
+	switch {
+	case strings.HasPrefix(firstLine, "#!"):
+		for _, lang := range []string{"python", "ruby", "node", "perl"} {
+			if strings.Contains(firstLine, lang) {
+				if lang == "node" {
+					return "javascript"
+				}
+				return lang
+			}
+		}
+		return "sh"
+	case strings.HasPrefix(trimmed, "package ") || strings.Contains(code, "func ") && strings.Contains(code, ":= "):
+		return "go"
+	case strings.HasPrefix(trimmed, "#include"):
+		return "c"
+	case strings.HasPrefix(trimmed, "<?php"):
+		return "php"
+	case strings.HasPrefix(trimmed, "<"):
+		return "html"
+	case strings.HasPrefix(trimmed, "$ "):
+		return "console"
+	case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+		if json.Valid([]byte(trimmed)) {
+			return "json"
+		}
+	case strings.Contains(code, "def ") && strings.Contains(code, "):") || strings.HasPrefix(trimmed, "import ") && !strings.Contains(code, ";"):
+		return "python"
+	case strings.Contains(code, "function ") || strings.Contains(code, "const ") && strings.Contains(code, "=>"):
+		return "javascript"
+	}
+	upper := strings.ToUpper(trimmed)
+	for _, kw := range []string{"SELECT ", "INSERT INTO ", "CREATE TABLE ", "UPDATE "} {
+		if strings.HasPrefix(upper, kw) {
+			return "sql"
+		}
+	}
+	return ""
+}
+
 func (mc *MarkdownConverter) tableAsMarkdown(table *docs.Table) []string {
+	mc.endList()
+	defer mc.endList()
+	switch mc.TableFormat {
+	case TableHTML:
+		return mc.tableAsHTML(table)
+	case TableAuto:
+		if !isGFMTable(table) {
+			return mc.tableAsHTML(table)
+		}
+	}
 	var md []string
 	for i, row := range table.TableRows {
 		var rowMd []string
 		for _, cell := range row.TableCells {
-			// process each cell's content
+			// GFM cells hold a single line, so paragraphs are separated by
+			// line breaks.
 			var cellMd []string
-			for _, content := range cell.Content {
-				if content.Paragraph != nil {
-					cellMd = append(cellMd, strings.Join(mc.paragraphAsMarkdown(content.Paragraph), " "))
-					// trim trailing newline:
-					cellMd[len(cellMd)-1] = strings.TrimRight(cellMd[len(cellMd)-1], "\n")
+			for _, line := range mc.contentMarkdown(cell.Content) {
+				if line != "" && line != "\n" && line != "<!-- -->" {
+					line = strings.ReplaceAll(line, mc.LineBreaks.marker()+"\n", "<br>")
+					cellMd = append(cellMd, strings.ReplaceAll(line, "|", "\\|"))
 				}
 			}
-			rowMd = append(rowMd, strings.Join(cellMd, " "))
+			rowMd = append(rowMd, strings.Join(cellMd, "<br>"))
 		}
 		md = append(md, "| "+strings.Join(rowMd, " | ")+" |")
 		if i == 0 {
@@ -86,3 +845,86 @@ func (mc *MarkdownConverter) tableAsMarkdown(table *docs.Table) []string {
 	md = append(md, "\n") // add newline after each table
 	return md
 }
+
+// isGFMTable reports whether a table can be expressed as a GFM table, which
+// has no merged cells and no block content other than paragraphs.
+func isGFMTable(table *docs.Table) bool {
+	for _, row := range table.TableRows {
+		for _, cell := range row.TableCells {
+			if style := cell.TableCellStyle; style != nil && (style.ColumnSpan > 1 || style.RowSpan > 1) {
+				return false
+			}
+			for _, content := range cell.Content {
+				if content.Paragraph == nil || content.Paragraph.Bullet != nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// contentMarkdown returns the Markdown lines of content nested in another
+// element, such as a table cell or footnote.
+func (mc *MarkdownConverter) contentMarkdown(content []*docs.StructuralElement) []string {
+	mc.endList()
+	defer mc.endList()
+	var md []string
+	for _, content := range content {
+		if content.Paragraph != nil {
+			md = append(md, mc.paragraphAsMarkdown(content.Paragraph)...)
+		}
+	}
+	return md
+}
+
+// tableAsHTML returns a table as an HTML table, with the first row as its
+// header.
+func (mc *MarkdownConverter) tableAsHTML(table *docs.Table) []string {
+	md := []string{"<table>"}
+	// covered marks the grid positions taken up by merged cells.
+	covered := map[[2]int]bool{}
+	for i, row := range table.TableRows {
+		tag := "td"
+		if i == 0 {
+			tag = "th"
+		}
+		md = append(md, "<tr>")
+		col := 0
+		for j, cell := range row.TableCells {
+			if int64(len(row.TableCells)) == table.Columns {
+				// Cells covered by a merge are listed but empty.
+				col = j
+				if covered[[2]int{i, col}] {
+					continue
+				}
+			} else {
+				for covered[[2]int{i, col}] {
+					col++
+				}
+			}
+			attrs := ""
+			rowSpan, colSpan := int64(1), int64(1)
+			if style := cell.TableCellStyle; style != nil {
+				if style.RowSpan > 1 {
+					rowSpan = style.RowSpan
+					attrs += fmt.Sprintf(` rowspan="%d"`, rowSpan)
+				}
+				if style.ColumnSpan > 1 {
+					colSpan = style.ColumnSpan
+					attrs += fmt.Sprintf(` colspan="%d"`, colSpan)
+				}
+			}
+			for r := 0; r < int(rowSpan); r++ {
+				for c := 0; c < int(colSpan); c++ {
+					covered[[2]int{i + r, col + c}] = true
+				}
+			}
+			html := markdownToHTML(strings.Join(mc.contentMarkdown(cell.Content), "\n"))
+			md = append(md, fmt.Sprintf("<%s%s>%s</%s>", tag, attrs, html, tag))
+			col += int(colSpan)
+		}
+		md = append(md, "</tr>")
+	}
+	return append(md, "</table>", "\n")
+}
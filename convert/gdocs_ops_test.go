@@ -0,0 +1,866 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yuin/goldmark/text"
+	"google.golang.org/api/docs/v1"
+)
+
+func textRun(content string) *docs.ParagraphElement {
+	return &docs.ParagraphElement{
+		TextRun: &docs.TextRun{
+			Content:   content,
+			TextStyle: &docs.TextStyle{},
+		},
+	}
+}
+
+func paragraph(style string, elems ...*docs.ParagraphElement) *docs.StructuralElement {
+	return &docs.StructuralElement{
+		Paragraph: &docs.Paragraph{
+			Elements:       elems,
+			ParagraphStyle: &docs.ParagraphStyle{NamedStyleType: style},
+		},
+	}
+}
+
+func listItem(listID string, level int64, text string) *docs.StructuralElement {
+	s := paragraph("NORMAL_TEXT", textRun(text+"\n"))
+	s.Paragraph.Bullet = &docs.Bullet{ListId: listID, NestingLevel: level}
+	return s
+}
+
+func struckThrough(s *docs.StructuralElement) *docs.StructuralElement {
+	for _, elem := range s.Paragraph.Elements {
+		elem.TextRun.TextStyle.Strikethrough = true
+	}
+	return s
+}
+
+func bulletList(levels ...*docs.NestingLevel) docs.List {
+	return docs.List{ListProperties: &docs.ListProperties{NestingLevels: levels}}
+}
+
+var (
+	decimalLevel = &docs.NestingLevel{GlyphType: "DECIMAL", GlyphFormat: "%0."}
+	discLevel    = &docs.NestingLevel{GlyphSymbol: "●"}
+)
+
+func TestAsMarkdownLists(t *testing.T) {
+	tests := []struct {
+		name    string
+		indent  int
+		lists   map[string]docs.List
+		content []*docs.StructuralElement
+		want    string
+	}{
+		{
+			name:  "unordered",
+			lists: map[string]docs.List{"a": bulletList(discLevel)},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 0, "two"),
+			},
+			want: "# Doc\n* one\n* two",
+		},
+		{
+			name:  "ordered",
+			lists: map[string]docs.List{"a": bulletList(decimalLevel)},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 0, "two"),
+				listItem("a", 0, "three"),
+			},
+			want: "# Doc\n1. one\n2. two\n3. three",
+		},
+		{
+			name: "start number and paren format",
+			lists: map[string]docs.List{"a": bulletList(&docs.NestingLevel{
+				GlyphType:   "UPPER_ROMAN",
+				GlyphFormat: "%0)",
+				StartNumber: 4,
+			})},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "four"),
+				listItem("a", 0, "five"),
+			},
+			want: "# Doc\n4) four\n5) five",
+		},
+		{
+			name: "restart",
+			lists: map[string]docs.List{
+				"a": bulletList(decimalLevel),
+				"b": bulletList(decimalLevel),
+			},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 0, "two"),
+				listItem("b", 0, "again"),
+			},
+			want: "# Doc\n1. one\n2. two\n\n<!-- -->\n1. again",
+		},
+		{
+			name:  "interrupted list continues numbering",
+			lists: map[string]docs.List{"a": bulletList(decimalLevel)},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				paragraph("NORMAL_TEXT", textRun("text\n")),
+				listItem("a", 0, "two"),
+			},
+			want: "# Doc\n1. one\n\ntext\n\n\n2. two",
+		},
+		{
+			name: "nested",
+			lists: map[string]docs.List{
+				"a": bulletList(decimalLevel, discLevel, decimalLevel),
+			},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 1, "sub"),
+				listItem("a", 2, "first"),
+				listItem("a", 2, "second"),
+				listItem("a", 1, "sub"),
+				listItem("a", 2, "restarted"),
+				listItem("a", 0, "two"),
+			},
+			want: "# Doc\n" +
+				"1. one\n" +
+				"    * sub\n" +
+				"        1. first\n" +
+				"        2. second\n" +
+				"    * sub\n" +
+				"        1. restarted\n" +
+				"2. two",
+		},
+		{
+			name:   "narrow indent",
+			indent: 2,
+			lists:  map[string]docs.List{"a": bulletList(decimalLevel, discLevel, discLevel)},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 1, "sub"),
+				listItem("a", 2, "subsub"),
+			},
+			want: "# Doc\n1. one\n   * sub\n     * subsub",
+		},
+		{
+			name: "checklist",
+			lists: map[string]docs.List{
+				"a": bulletList(&docs.NestingLevel{GlyphType: "GLYPH_TYPE_UNSPECIFIED"}),
+			},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "open"),
+				struckThrough(listItem("a", 0, "done")),
+			},
+			want: "# Doc\n- [ ] open\n- [x] done",
+		},
+		{
+			name:  "skipped level",
+			lists: map[string]docs.List{"a": bulletList(discLevel, discLevel, discLevel)},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 2, "deep"),
+			},
+			want: "# Doc\n* one\n     * deep",
+		},
+		{
+			name:   "wide indent",
+			indent: 8,
+			lists:  map[string]docs.List{"a": bulletList(discLevel, decimalLevel)},
+			content: []*docs.StructuralElement{
+				listItem("a", 0, "one"),
+				listItem("a", 1, "sub"),
+			},
+			want: "# Doc\n* one\n     1. sub",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &docs.Document{
+				Title: "Doc",
+				Lists: tt.lists,
+				Body:  &docs.Body{Content: tt.content},
+			}
+			mc := NewMarkdownConverter()
+			if tt.indent != 0 {
+				mc.ListIndent = tt.indent
+			}
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func monospaceRun(content string) *docs.ParagraphElement {
+	elem := textRun(content)
+	elem.TextRun.TextStyle.WeightedFontFamily = &docs.WeightedFontFamily{FontFamily: "Courier New"}
+	return elem
+}
+
+func TestAsMarkdownCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		infer   bool
+		content []*docs.StructuralElement
+		want    string
+	}{
+		{
+			name: "whitespace preserved",
+			content: []*docs.StructuralElement{
+				paragraph("NORMAL_TEXT", textRun("Run:\n")),
+				paragraph("NORMAL_TEXT", monospaceRun("if x {\n")),
+				paragraph("NORMAL_TEXT", monospaceRun("\treturn  1 \n")),
+				paragraph("NORMAL_TEXT", monospaceRun("\n")),
+				paragraph("NORMAL_TEXT", monospaceRun("}\n")),
+				paragraph("NORMAL_TEXT", monospaceRun("\n")),
+				paragraph("NORMAL_TEXT", textRun("Done\n")),
+			},
+			want: "# Doc\nRun:\n\n\n```\nif x {\n\treturn  1 \n\n}\n```\n\n\n\n\n\nDone\n\n",
+		},
+		{
+			name: "backticks in code",
+			content: []*docs.StructuralElement{
+				paragraph("NORMAL_TEXT", monospaceRun("```\n")),
+			},
+			want: "# Doc\n````\n```\n````\n\n",
+		},
+		{
+			name:  "inferred language",
+			infer: true,
+			content: []*docs.StructuralElement{
+				paragraph("NORMAL_TEXT", monospaceRun("package main\n")),
+			},
+			want: "# Doc\n```go\npackage main\n```\n\n",
+		},
+		{
+			name: "blank monospace paragraph",
+			content: []*docs.StructuralElement{
+				paragraph("NORMAL_TEXT", monospaceRun("\n")),
+			},
+			want: "# Doc\n\n\n",
+		},
+		{
+			name: "monospace heading",
+			content: []*docs.StructuralElement{
+				paragraph("HEADING_2", monospaceRun("config.yaml\n")),
+			},
+			want: "# Doc\n## `config.yaml`\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &docs.Document{
+				Title: "Doc",
+				Body:  &docs.Body{Content: tt.content},
+			}
+			mc := NewMarkdownConverter()
+			mc.InferCodeLanguage = tt.infer
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInferCodeLanguage(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"#!/bin/bash\necho hi", "sh"},
+		{"#!/usr/bin/env python3\nprint(1)", "python"},
+		{"package main\n\nfunc main() {}", "go"},
+		{"def f(x):\n    return x", "python"},
+		{"#include <stdio.h>", "c"},
+		{`{"a": 1}`, "json"},
+		{"select * from t", "sql"},
+		{"just some words", ""},
+	}
+	for _, tt := range tests {
+		if got := inferCodeLanguage(tt.code); got != tt.want {
+			t.Errorf("inferCodeLanguage(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func tableCell(content ...*docs.StructuralElement) *docs.TableCell {
+	return &docs.TableCell{Content: content, TableCellStyle: &docs.TableCellStyle{}}
+}
+
+func table(rows ...[]*docs.TableCell) *docs.StructuralElement {
+	t := &docs.Table{Rows: int64(len(rows)), Columns: int64(len(rows[0]))}
+	for _, cells := range rows {
+		t.TableRows = append(t.TableRows, &docs.TableRow{TableCells: cells})
+	}
+	return &docs.StructuralElement{Table: t}
+}
+
+func TestAsMarkdownTables(t *testing.T) {
+	text := func(s string) *docs.StructuralElement {
+		return paragraph("NORMAL_TEXT", textRun(s+"\n"))
+	}
+	merged := tableCell(text("wide"))
+	merged.TableCellStyle.ColumnSpan = 2
+	mergedTable := table(
+		[]*docs.TableCell{merged, tableCell(text(""))},
+		[]*docs.TableCell{tableCell(text("a")), tableCell(text("b"))},
+	)
+	under := textRun("under")
+	under.TextRun.TextStyle.Underline = true
+	underlined := tableCell(paragraph("NORMAL_TEXT", under, textRun("\n")))
+	underlined.TableCellStyle.ColumnSpan = 2
+	listTable := table(
+		[]*docs.TableCell{tableCell(text("Steps"))},
+		[]*docs.TableCell{tableCell(listItem("l", 0, "one"), listItem("l", 0, "two"))},
+	)
+	tests := []struct {
+		name   string
+		format TableFormat
+		table  *docs.StructuralElement
+		want   string
+	}{
+		{
+			name: "gfm",
+			table: table(
+				[]*docs.TableCell{tableCell(text("a|b")), tableCell(text("c"))},
+				[]*docs.TableCell{tableCell(text("one"), text("two")), tableCell(text(""))},
+			),
+			want: "# Doc\n| a\\|b | c |\n| --- | --- |\n| one<br>two |  |\n\n",
+		},
+		{
+			name:  "merged cells fall back to html",
+			table: mergedTable,
+			want: "# Doc\n<table>\n<tr>\n<th colspan=\"2\">wide</th>\n</tr>\n" +
+				"<tr>\n<td>a</td>\n<td>b</td>\n</tr>\n</table>\n\n",
+		},
+		{
+			name: "html in merged cells",
+			table: table(
+				[]*docs.TableCell{underlined, tableCell(text(""))},
+				[]*docs.TableCell{tableCell(text("a")), tableCell(text("b"))},
+			),
+			want: "# Doc\n<table>\n<tr>\n<th colspan=\"2\"><u>under</u></th>\n</tr>\n" +
+				"<tr>\n<td>a</td>\n<td>b</td>\n</tr>\n</table>\n\n",
+		},
+		{
+			name:  "lists fall back to html",
+			table: listTable,
+			want: "# Doc\n<table>\n<tr>\n<th>Steps</th>\n</tr>\n" +
+				"<tr>\n<td><ul>\n<li>one</li>\n<li>two</li>\n</ul></td>\n</tr>\n</table>\n\n",
+		},
+		{
+			name:   "forced gfm",
+			format: TableGFM,
+			table:  listTable,
+			want:   "# Doc\n| Steps |\n| --- |\n| * one<br>* two |\n\n",
+		},
+		{
+			name:   "forced html",
+			format: TableHTML,
+			table:  table([]*docs.TableCell{tableCell(text("x"))}),
+			want:   "# Doc\n<table>\n<tr>\n<th>x</th>\n</tr>\n</table>\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &docs.Document{
+				Title: "Doc",
+				Body:  &docs.Body{Content: []*docs.StructuralElement{tt.table}},
+			}
+			mc := NewMarkdownConverter()
+			mc.TableFormat = tt.format
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownFootnotes(t *testing.T) {
+	ref := func(id, number string) *docs.ParagraphElement {
+		return &docs.ParagraphElement{FootnoteReference: &docs.FootnoteReference{FootnoteId: id, FootnoteNumber: number}}
+	}
+	doc := &docs.Document{
+		Title: "Doc",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun("Claim"), ref("kix.a", "1"), textRun(" more"), ref("kix.b", "2"), textRun("\n")),
+		}},
+		Footnotes: map[string]docs.Footnote{
+			"kix.a": {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun(" First source.\n"))}},
+			"kix.b": {Content: []*docs.StructuralElement{
+				paragraph("NORMAL_TEXT", textRun(" Second.\n")),
+				paragraph("NORMAL_TEXT", textRun("Details.\n")),
+			}},
+		},
+	}
+	got, err := NewMarkdownConverter().AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	want := "# Doc\nClaim[^1] more[^2]\n\n\n\n" +
+		"[^1]: First source.\n\n" +
+		"[^2]: Second.\n\n    Details."
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAsMarkdownHeadersFooters(t *testing.T) {
+	doc := &docs.Document{
+		Title:         "Doc",
+		DocumentStyle: &docs.DocumentStyle{DefaultHeaderId: "kix.h", DefaultFooterId: "kix.f"},
+		Headers: map[string]docs.Header{
+			"kix.h": {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun("v1_final [draft]\n"))}},
+		},
+		Footers: map[string]docs.Footer{
+			"kix.f": {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun("Page\n"))}},
+		},
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun("Body\n")),
+		}},
+	}
+	tests := []struct {
+		name string
+		mode HeaderFooterMode
+		want string
+	}{
+		{
+			name: "omitted",
+			mode: HeadersFootersOmitted,
+			want: "# Doc\nBody\n\n",
+		},
+		{
+			name: "front matter",
+			mode: HeadersFootersFrontMatter,
+			want: "---\nheader: v1_final [draft]\nfooter: Page\n---\n# Doc\nBody\n\n",
+		},
+		{
+			name: "sections",
+			mode: HeadersFootersSections,
+			want: "# Doc\n<!-- header -->\nv1\\_final \\[draft\\]\n<!-- /header -->\n\nBody\n\n\n\n<!-- footer -->\nPage\n<!-- /footer -->",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMarkdownConverter()
+			mc.HeadersFooters = tt.mode
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownFrontMatter(t *testing.T) {
+	doc := &docs.Document{
+		DocumentId: "abc123",
+		Title:      "Design: v2",
+		RevisionId: "rev7",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun("Body\n")),
+		}},
+	}
+	mc := NewMarkdownConverter()
+	mc.FrontMatter = true
+	mc.now = func() time.Time { return time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC) }
+	got, err := mc.AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	want := "---\n" +
+		"document_id: abc123\n" +
+		"title: 'Design: v2'\n" +
+		"revision_id: rev7\n" +
+		"last_synced: 2024-03-01T12:30:00Z\n" +
+		"source_url: https://docs.google.com/document/d/abc123/edit\n" +
+		"---\n" +
+		"Body\n\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAsMarkdownBreaks(t *testing.T) {
+	rule := paragraph("NORMAL_TEXT", textRun("\n"))
+	rule.Paragraph.Elements = append([]*docs.ParagraphElement{{HorizontalRule: &docs.HorizontalRule{}}}, rule.Paragraph.Elements...)
+	pageBreak := paragraph("NORMAL_TEXT", textRun("Before"), textRun("\n"))
+	pageBreak.Paragraph.Elements = append(pageBreak.Paragraph.Elements[:1], &docs.ParagraphElement{PageBreak: &docs.PageBreak{}}, pageBreak.Paragraph.Elements[1])
+	bordered := paragraph("NORMAL_TEXT", textRun("\n"))
+	bordered.Paragraph.ParagraphStyle.BorderBottom = &docs.ParagraphBorder{Width: &docs.Dimension{Magnitude: 1, Unit: "PT"}}
+	doc := &docs.Document{
+		Title: "Doc",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			{SectionBreak: &docs.SectionBreak{}},
+			paragraph("NORMAL_TEXT", textRun("One\n")),
+			rule,
+			pageBreak,
+			{SectionBreak: &docs.SectionBreak{}},
+			bordered,
+			paragraph("NORMAL_TEXT", textRun("Two\n")),
+		}},
+	}
+	tests := []struct {
+		name    string
+		markers bool
+		want    string
+	}{
+		{
+			name:    "markers",
+			markers: true,
+			want:    "# Doc\nOne\n\n\n---\n\n\nBefore\n\n<!-- pagebreak -->\n\n\n<!-- sectionbreak -->\n\n\n---\n\n\nTwo\n\n",
+		},
+		{
+			name: "no markers",
+			want: "# Doc\nOne\n\n\n---\n\n\nBefore\n\n\n---\n\n\nTwo\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMarkdownConverter()
+			if !tt.markers {
+				mc.PageBreakMarker = ""
+				mc.SectionBreakMarker = ""
+			}
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownLineBreaks(t *testing.T) {
+	bold := textRun("bold\v")
+	bold.TextRun.TextStyle.Bold = true
+	doc := &docs.Document{
+		Title: "Doc",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("HEADING_1", textRun("Split\vheading\n")),
+			paragraph("NORMAL_TEXT", textRun("One \v- two\n")),
+			paragraph("NORMAL_TEXT", bold, textRun("plain\v\n")),
+			listItem("list", 0, "Item\vcontinued"),
+		}},
+		Lists: map[string]docs.List{"list": bulletList(discLevel)},
+	}
+	tests := []struct {
+		name  string
+		style LineBreakStyle
+		want  string
+	}{
+		{
+			name:  "backslash",
+			style: LineBreaksBackslash,
+			want:  "# Doc\n# Split heading\n\n\nOne\\\n\\- two\n\n\n**bold**\\\nplain\n\n\n* Item\\\n  continued",
+		},
+		{
+			name:  "spaces",
+			style: LineBreaksSpaces,
+			want:  "# Doc\n# Split heading\n\n\nOne  \n\\- two\n\n\n**bold**  \nplain\n\n\n* Item  \n  continued",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMarkdownConverter()
+			mc.LineBreaks = tt.style
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownTableOfContents(t *testing.T) {
+	heading := func(style, id, text string) *docs.StructuralElement {
+		h := paragraph(style, textRun(text+"\n"))
+		h.Paragraph.ParagraphStyle.HeadingId = id
+		return h
+	}
+	for _, tt := range []struct {
+		name string
+		link func(id string) *docs.Link
+		tabs bool
+	}{
+		{"legacy", func(id string) *docs.Link { return &docs.Link{HeadingId: id} }, false},
+		{"tabs content", func(id string) *docs.Link {
+			return &docs.Link{Heading: &docs.HeadingLink{Id: id, TabId: "t.0"}, TabId: "t.0"}
+		}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := func(id, text string) *docs.StructuralElement {
+				e := paragraph("NORMAL_TEXT", textRun(text), textRun("\n"))
+				e.Paragraph.Elements[0].TextRun.TextStyle.Link = tt.link(id)
+				return e
+			}
+			doc := &docs.Document{
+				Title: "Doc",
+				Body: &docs.Body{Content: []*docs.StructuralElement{
+					{TableOfContents: &docs.TableOfContents{Content: []*docs.StructuralElement{
+						entry("h.1", "Getting started"),
+						entry("h.2", "Install"),
+						entry("h.3", "Doc"),
+					}}},
+					heading("HEADING_1", "h.1", "Getting started"),
+					heading("HEADING_2", "h.2", "Install"),
+					heading("HEADING_1", "h.3", "Doc"),
+				}},
+			}
+			if tt.tabs {
+				doc = withTabsContent(doc)
+			}
+			mc := NewMarkdownConverter()
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			// The title heading takes the "doc" anchor.
+			want := "# Doc\n" +
+				"- [Getting started](#getting-started)\n" +
+				"    - [Install](#install)\n" +
+				"- [Doc](#doc-1)\n\n\n" +
+				"# Getting started\n\n\n" +
+				"## Install\n\n\n" +
+				"# Doc\n\n"
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownInternalLinks(t *testing.T) {
+	link := func(text string, l *docs.Link) *docs.ParagraphElement {
+		run := textRun(text)
+		run.TextRun.TextStyle.Link = l
+		return run
+	}
+	for _, tt := range []struct {
+		name              string
+		heading, bookmark *docs.Link
+		tabs              bool
+	}{
+		{"legacy", &docs.Link{HeadingId: "h.setup"}, &docs.Link{BookmarkId: "id.x1y2"}, false},
+		{
+			"tabs content",
+			&docs.Link{Heading: &docs.HeadingLink{Id: "h.setup", TabId: "t.0"}, TabId: "t.0"},
+			&docs.Link{Bookmark: &docs.BookmarkLink{Id: "id.x1y2", TabId: "t.0"}, TabId: "t.0"},
+			true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			heading := paragraph("HEADING_1", textRun("Set up: the basics\n"))
+			heading.Paragraph.ParagraphStyle.HeadingId = "h.setup"
+			doc := &docs.Document{
+				Title: "Doc",
+				Body: &docs.Body{Content: []*docs.StructuralElement{
+					heading,
+					paragraph("NORMAL_TEXT",
+						link("setup", tt.heading),
+						link("mark", tt.bookmark),
+						link("site", &docs.Link{Url: "https://example.com"}),
+						textRun("\n"),
+					),
+				}},
+			}
+			if tt.tabs {
+				doc = withTabsContent(doc)
+			}
+			got, err := NewMarkdownConverter().AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			want := "# Doc\n# Set up: the basics\n\n\n" +
+				"[setup](#set-up-the-basics)[mark](#bookmark-x1y2)[site](https://example.com)\n\n"
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownHeadingAnchors(t *testing.T) {
+	link := textRun("x")
+	link.TextRun.TextStyle.Link = &docs.Link{Url: "http://y.com"}
+	linked := paragraph("HEADING_2", textRun("See "), link, textRun(" now\n"))
+	linked.Paragraph.ParagraphStyle.HeadingId = "h.see"
+	escaped := paragraph("HEADING_2", textRun("snake_case *args*\n"))
+	escaped.Paragraph.ParagraphStyle.HeadingId = "h.snake"
+	toHeading := func(text, id string) *docs.ParagraphElement {
+		run := textRun(text)
+		run.TextRun.TextStyle.Link = &docs.Link{HeadingId: id}
+		return run
+	}
+	doc := &docs.Document{
+		Title: "Doc",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			linked,
+			escaped,
+			paragraph("NORMAL_TEXT", toHeading("see", "h.see"), textRun(", "), toHeading("snake", "h.snake"), textRun("\n")),
+		}},
+	}
+	got, err := NewMarkdownConverter().AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	// The links must point at the IDs goldmark gives the exported headings.
+	root := NewMarkdownParser().Parse(text.NewReader(got))
+	var anchors []string
+	for _, h := range markdownHeadings(root, got)[1:] {
+		anchors = append(anchors, "#"+h.anchor)
+	}
+	want := []string{"#see-xhttpycom-now", "#snake-case-args"}
+	if diff := cmp.Diff(want, anchors); diff != "" {
+		t.Errorf("heading anchors mismatch (-want +got):\n%s", diff)
+	}
+	if wantLinks := "[see](" + want[0] + "), [snake](" + want[1] + ")"; !strings.Contains(string(got), wantLinks) {
+		t.Errorf("AsMarkdown = %q, want links %q", got, wantLinks)
+	}
+}
+
+func TestAsMarkdownChips(t *testing.T) {
+	doc := &docs.Document{
+		Title: "Status",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT",
+				&docs.ParagraphElement{Person: &docs.Person{PersonProperties: &docs.PersonProperties{Name: "Ada Lovelace", Email: "ada@example.com"}}},
+				&docs.ParagraphElement{Person: &docs.Person{PersonProperties: &docs.PersonProperties{Name: "Grace"}}},
+				&docs.ParagraphElement{RichLink: &docs.RichLink{RichLinkProperties: &docs.RichLinkProperties{Title: "Plan", Uri: "https://docs.google.com/document/d/plan"}}},
+				&docs.ParagraphElement{DateElement: &docs.DateElement{DateElementProperties: &docs.DateElementProperties{
+					Timestamp:   "2024-05-31T23:00:00Z",
+					TimeZoneId:  "Asia/Tokyo",
+					DisplayText: "Jun 1, 2024",
+				}}},
+				textRun("\n"),
+			),
+		}},
+	}
+	tests := []struct {
+		name      string
+		templates ChipTemplates
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "default",
+			templates: DefaultChipTemplates,
+			want:      "# Status\n[Ada Lovelace](mailto:ada@example.com)@Grace[Plan](https://docs.google.com/document/d/plan)2024-06-01\n\n",
+		},
+		{
+			name: "custom",
+			templates: ChipTemplates{
+				Person:   `@{{.Name}}`,
+				RichLink: `<{{.Uri}}>`,
+				Date:     `{{.DisplayText}}`,
+			},
+			want: "# Status\n@Ada Lovelace@Grace<https://docs.google.com/document/d/plan>Jun 1, 2024\n\n",
+		},
+		{
+			name:      "invalid",
+			templates: ChipTemplates{Person: `{{.Phone}}`},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMarkdownConverter()
+			mc.ChipTemplates = tt.templates
+			got, err := mc.AsMarkdown(doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AsMarkdown error = %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAsMarkdownChipsEscaped(t *testing.T) {
+	doc := &docs.Document{
+		Title: "Status",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT",
+				&docs.ParagraphElement{Person: &docs.Person{PersonProperties: &docs.PersonProperties{Name: "Ada_L [ops]", Email: "ada@example.com"}}},
+				textRun(" "),
+				&docs.ParagraphElement{Person: &docs.Person{PersonProperties: &docs.PersonProperties{Name: "*Grace*"}}},
+				textRun(" "),
+				&docs.ParagraphElement{RichLink: &docs.RichLink{RichLinkProperties: &docs.RichLinkProperties{Title: "*Draft* [v2]", Uri: "https://example.com/a (b)"}}},
+				textRun("\n"),
+			),
+		}},
+	}
+	got, err := NewMarkdownConverter().AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	md := strings.TrimPrefix(strings.TrimSpace(string(got)), "# Status\n")
+	want := `<a href="mailto:ada@example.com">Ada_L [ops]</a> @*Grace* <a href="https://example.com/a%20(b)">*Draft* [v2]</a>`
+	if got := markdownToHTML(md); got != want {
+		t.Errorf("Markdown %q renders as %q, want %q", md, got, want)
+	}
+}
+
+func TestAsMarkdownSuggestions(t *testing.T) {
+	inserted := textRun("new ")
+	inserted.TextRun.SuggestedInsertionIds = []string{"suggest.1"}
+	deleted := textRun("old ")
+	deleted.TextRun.SuggestedDeletionIds = []string{"suggest.2"}
+	doc := &docs.Document{
+		Title: "Doc",
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun("Use the "), inserted, deleted, textRun("API\n")),
+		}},
+	}
+	tests := []struct {
+		mode         SuggestionsMode
+		wantViewMode string
+		want         string
+	}{
+		{SuggestionsInline, "SUGGESTIONS_INLINE", "# Doc\nUse the new old API\n\n"},
+		{SuggestionsAccepted, "PREVIEW_SUGGESTIONS_ACCEPTED", "# Doc\nUse the new API\n\n"},
+		{SuggestionsRejected, "PREVIEW_WITHOUT_SUGGESTIONS", "# Doc\nUse the old API\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantViewMode, func(t *testing.T) {
+			if got := tt.mode.ViewMode(); got != tt.wantViewMode {
+				t.Errorf("ViewMode() = %q, want %q", got, tt.wantViewMode)
+			}
+			mc := NewMarkdownConverter()
+			mc.Suggestions = tt.mode
+			got, err := mc.AsMarkdown(doc)
+			if err != nil {
+				t.Fatalf("AsMarkdown: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
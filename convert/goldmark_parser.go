@@ -1,17 +1,41 @@
 package convert
 
 import (
+	"bytes"
+	"html"
+	"strings"
+
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
 )
 
 func NewMarkdownParser() MarkdownParser {
 	gm := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithExtensions(extension.GFM, extension.Footnote),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
 		),
 	)
 	return gm.Parser()
 }
+
+// markdownToHTML renders a Markdown fragment as HTML, unwrapping a lone
+// paragraph so that it can be embedded inline. Raw HTML in the fragment,
+// such as underlines and comment markers, is kept.
+func markdownToHTML(md string) string {
+	var buf bytes.Buffer
+	gm := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+	)
+	if err := gm.Convert([]byte(md), &buf); err != nil {
+		return html.EscapeString(md)
+	}
+	out := strings.TrimSpace(buf.String())
+	if strings.HasPrefix(out, "<p>") && strings.HasSuffix(out, "</p>") && strings.Count(out, "<p>") == 1 {
+		out = strings.TrimSuffix(strings.TrimPrefix(out, "<p>"), "</p>")
+	}
+	return out
+}
@@ -0,0 +1,293 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"google.golang.org/api/docs/v1"
+)
+
+// imageExtensions maps image content types to the extension used for their
+// downloaded files.
+var imageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+	"image/bmp":     ".bmp",
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// embeddedImage returns the embedded object of an inline image, or nil if the
+// inline object is not an image.
+func embeddedImage(obj docs.InlineObject) *docs.EmbeddedObject {
+	if obj.InlineObjectProperties == nil || obj.InlineObjectProperties.EmbeddedObject == nil {
+		return nil
+	}
+	embedded := obj.InlineObjectProperties.EmbeddedObject
+	if embedded.ImageProperties == nil || embedded.ImageProperties.ContentUri == "" {
+		return nil
+	}
+	return embedded
+}
+
+// downloadImages downloads the document's inline images into AssetsDir and
+// adds their paths to mc.images. Each image is named after its object ID so
+// that repeated exports of a document produce the same files.
+func (mc *MarkdownConverter) downloadImages(doc *docs.Document) error {
+	if mc.AssetsDir == "" {
+		return nil
+	}
+	for id, obj := range doc.InlineObjects {
+		embedded := embeddedImage(obj)
+		if embedded == nil {
+			continue
+		}
+		path, err := mc.downloadImage(embedded.ImageProperties.ContentUri, unsafeFilenameChars.ReplaceAllString(id, "_"))
+		if err != nil {
+			return fmt.Errorf("unable to download image %s: %w", id, err)
+		}
+		mc.images[id] = path
+	}
+	return nil
+}
+
+// downloadImage saves the image at uri into AssetsDir under name, with an
+// extension matching its content type, and returns the file's path.
+func (mc *MarkdownConverter) downloadImage(uri, name string) (string, error) {
+	client := mc.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(uri)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	if err := os.MkdirAll(mc.AssetsDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(mc.AssetsDir, name+ext)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// markdownPath returns path relative to MarkdownDir, if it is set and path
+// can be made relative to it.
+func (mc *MarkdownConverter) markdownPath(path string) string {
+	if mc.MarkdownDir == "" {
+		return path
+	}
+	base, err := filepath.Abs(mc.MarkdownDir)
+	if err != nil {
+		return path
+	}
+	target, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(base, target); err == nil {
+		return rel
+	}
+	return path
+}
+
+// inlineObjectAsMarkdown returns the Markdown image for an inline object,
+// using its description, or failing that its title, as alt text.
+func (mc *MarkdownConverter) inlineObjectAsMarkdown(elem *docs.InlineObjectElement) string {
+	if mc.doc == nil {
+		return ""
+	}
+	embedded := embeddedImage(mc.doc.InlineObjects[elem.InlineObjectId])
+	if embedded == nil {
+		return ""
+	}
+	dest := embedded.ImageProperties.ContentUri
+	if path, ok := mc.images[elem.InlineObjectId]; ok {
+		dest = filepath.ToSlash(mc.markdownPath(path))
+	}
+	dest = markdownDestination(dest)
+	alt := embedded.Description
+	if alt == "" {
+		alt = embedded.Title
+	}
+	alt = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "\n", " ").Replace(alt)
+	return fmt.Sprintf("![%s](%s)", alt, dest)
+}
+
+// imageRequest returns the request inserting a Markdown image, uploading
+// local images with the ImageUploader. The image is sized from its width and
+// height attributes, given in pixels, falling back to its intrinsic size, and scaled down to
+// fit MaxImageWidth. Local images are skipped, returning nil, if there is no
+// ImageUploader.
+func (dc *DocConverter) imageRequest(ctx context.Context, n *ast.Image) (*docs.InsertInlineImageRequest, error) {
+	dest := string(n.Destination)
+	var uri string
+	var data []byte
+	if u, err := url.Parse(dest); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		uri = dest
+		// The size is only a hint, so images that cannot be fetched are
+		// left for Docs to report.
+		data, _ = dc.fetchImage(ctx, uri)
+	} else {
+		if dc.ImageUploader == nil {
+			// Docs has nowhere to fetch the image from, but that should not
+			// keep the rest of the document from being written.
+			log.Printf("skipping local image %s: no image uploader", dest)
+			return nil, nil
+		}
+		path := dest
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dc.ImageBaseDir, path)
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("unable to read image: %w", err)
+		}
+		if uri, err = dc.ImageUploader.Upload(ctx, path); err != nil {
+			return nil, fmt.Errorf("unable to upload image %s: %w", dest, err)
+		}
+	}
+
+	var width, height float64
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = pixelsToPoints(float64(cfg.Width)), pixelsToPoints(float64(cfg.Height))
+	}
+	attrWidth, hasWidth := imageAttribute(n, "width")
+	attrHeight, hasHeight := imageAttribute(n, "height")
+	switch {
+	case hasWidth && hasHeight:
+		width, height = attrWidth, attrHeight
+	case hasWidth:
+		if width > 0 {
+			height *= attrWidth / width
+		}
+		width = attrWidth
+	case hasHeight:
+		if height > 0 {
+			width *= attrHeight / height
+		}
+		height = attrHeight
+	}
+	if dc.MaxImageWidth > 0 && width > dc.MaxImageWidth {
+		height *= dc.MaxImageWidth / width
+		width = dc.MaxImageWidth
+	}
+
+	req := &docs.InsertInlineImageRequest{Uri: uri}
+	if width > 0 || height > 0 {
+		// Docs keeps the aspect ratio when only one dimension is given.
+		req.ObjectSize = &docs.Size{}
+		if width > 0 {
+			req.ObjectSize.Width = &docs.Dimension{Magnitude: width, Unit: "PT"}
+		}
+		if height > 0 {
+			req.ObjectSize.Height = &docs.Dimension{Magnitude: height, Unit: "PT"}
+		}
+	}
+	return req, nil
+}
+
+// fetchImage returns the content of a remote image.
+func (dc *DocConverter) fetchImage(ctx context.Context, uri string) ([]byte, error) {
+	client := dc.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// imageAttribute returns the size in points given by an image attribute.
+// Sizes are in pixels, with an optional "px" suffix.
+func imageAttribute(n *ast.Image, name string) (float64, bool) {
+	v, ok := n.AttributeString(name)
+	if !ok {
+		return 0, false
+	}
+	var s string
+	switch v := v.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	px, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "px"), 64)
+	if err != nil || px <= 0 {
+		return 0, false
+	}
+	return pixelsToPoints(px), true
+}
+
+// pixelsToPoints converts a length in CSS pixels to points.
+func pixelsToPoints(px float64) float64 {
+	return px * 0.75
+}
+
+// parseImageAttributes moves an attribute list written directly after an
+// image, as in ![alt](src){width=200}, from the following text onto the image.
+func parseImageAttributes(n *ast.Image, source []byte) {
+	next, ok := n.NextSibling().(*ast.Text)
+	if !ok || !bytes.HasPrefix(next.Segment.Value(source), []byte("{")) {
+		return
+	}
+	reader := text.NewReader(next.Segment.Value(source))
+	attrs, ok := parser.ParseAttributes(reader)
+	if !ok {
+		return
+	}
+	for _, attr := range attrs {
+		n.SetAttribute(attr.Name, attr.Value)
+	}
+	_, pos := reader.Position()
+	next.Segment = next.Segment.WithStart(next.Segment.Start + pos.Start)
+}
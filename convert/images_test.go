@@ -0,0 +1,211 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/docs/v1"
+)
+
+func inlineImage(uri, title, description string) docs.InlineObject {
+	return docs.InlineObject{
+		InlineObjectProperties: &docs.InlineObjectProperties{
+			EmbeddedObject: &docs.EmbeddedObject{
+				Title:           title,
+				Description:     description,
+				ImageProperties: &docs.ImageProperties{ContentUri: uri},
+			},
+		},
+	}
+}
+
+func TestAsMarkdownImages(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nfake")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer srv.Close()
+
+	doc := &docs.Document{
+		Title: "Doc",
+		InlineObjects: map[string]docs.InlineObject{
+			"kix.chart1": inlineImage(srv.URL+"/1", "Chart", "Revenue [2023]"),
+			"kix.logo":   inlineImage(srv.URL+"/2", "Logo", ""),
+		},
+		Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT",
+				textRun("Before "),
+				&docs.ParagraphElement{InlineObjectElement: &docs.InlineObjectElement{InlineObjectId: "kix.chart1"}},
+				&docs.ParagraphElement{InlineObjectElement: &docs.InlineObjectElement{InlineObjectId: "kix.logo"}},
+				textRun("\n"),
+			),
+		}},
+	}
+
+	t.Run("downloaded", func(t *testing.T) {
+		mdDir := t.TempDir()
+		dir := filepath.Join(mdDir, "assets")
+		mc := NewMarkdownConverter()
+		mc.AssetsDir = dir
+		mc.MarkdownDir = mdDir
+		got, err := mc.AsMarkdown(doc)
+		if err != nil {
+			t.Fatalf("AsMarkdown: %v", err)
+		}
+		want := "# Doc\nBefore ![Revenue \\[2023\\]](assets/kix.chart1.png)![Logo](assets/kix.logo.png)\n\n"
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "kix.chart1.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(png, data) {
+			t.Errorf("downloaded image = %q, want %q", data, png)
+		}
+	})
+
+	t.Run("linked", func(t *testing.T) {
+		got, err := NewMarkdownConverter().AsMarkdown(doc)
+		if err != nil {
+			t.Fatalf("AsMarkdown: %v", err)
+		}
+		want := "# Doc\nBefore ![Revenue \\[2023\\]](" + srv.URL + "/1)![Logo](" + srv.URL + "/2)\n\n"
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("download failure", func(t *testing.T) {
+		failing := httptest.NewServer(http.NotFoundHandler())
+		defer failing.Close()
+		mc := NewMarkdownConverter()
+		mc.AssetsDir = t.TempDir()
+		_, err := mc.AsMarkdown(&docs.Document{
+			InlineObjects: map[string]docs.InlineObject{"kix.x": inlineImage(failing.URL, "", "")},
+		})
+		if err == nil {
+			t.Fatal("AsMarkdown succeeded, want error")
+		}
+	})
+}
+
+// httpTestUploader is an ImageUploader that serves uploaded images from a
+// local HTTP server.
+type httpTestUploader struct {
+	srv   *httptest.Server
+	files map[string]string
+}
+
+func newHTTPTestUploader(t *testing.T) *httpTestUploader {
+	u := &httpTestUploader{files: map[string]string{}}
+	u.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := u.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, file)
+	}))
+	t.Cleanup(u.srv.Close)
+	return u
+}
+
+func (u *httpTestUploader) Upload(ctx context.Context, file string) (string, error) {
+	name := "/" + path.Base(filepath.ToSlash(file))
+	u.files[name] = file
+	return u.srv.URL + name, nil
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMarkdownToDocImages(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wide.png"), pngImage(t, 800, 400), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngImage(t, 100, 50))
+	}))
+	defer remote.Close()
+	uploader := newHTTPTestUploader(t)
+
+	dc := NewDocConverter()
+	dc.ImageBaseDir = dir
+	dc.ImageUploader = uploader
+	requests := markdownRequests(t, dc, "![wide](wide.png) ![small]("+remote.URL+"/small.png){width=200}")
+
+	size := func(width, height float64) *docs.Size {
+		return &docs.Size{
+			Width:  &docs.Dimension{Magnitude: width, Unit: "PT"},
+			Height: &docs.Dimension{Magnitude: height, Unit: "PT"},
+		}
+	}
+	want := []*docs.Request{
+		{InsertText: &docs.InsertTextRequest{Text: "\n", Location: &docs.Location{Index: 1}}},
+		{InsertInlineImage: &docs.InsertInlineImageRequest{
+			Uri:        uploader.srv.URL + "/wide.png",
+			Location:   &docs.Location{Index: 2},
+			ObjectSize: size(468, 234),
+		}},
+		{InsertText: &docs.InsertTextRequest{Text: " ", Location: &docs.Location{Index: 3}}},
+		{InsertInlineImage: &docs.InsertInlineImageRequest{
+			Uri:        remote.URL + "/small.png",
+			Location:   &docs.Location{Index: 4},
+			ObjectSize: size(150, 75),
+		}},
+	}
+	var got []*docs.Request
+	for _, r := range requests {
+		if r.InsertText != nil || r.InsertInlineImage != nil {
+			got = append(got, r)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	resp, err := http.Get(uploader.srv.URL + "/wide.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("uploaded image status = %s, want 200 OK", resp.Status)
+	}
+}
+
+func TestMarkdownToDocLocalImageWithoutUploader(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), pngImage(t, 1, 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dc := NewDocConverter()
+	dc.ImageBaseDir = dir
+	requests := markdownRequests(t, dc, "Before ![a](a.png) after")
+	for _, r := range requests {
+		if r.InsertInlineImage != nil {
+			t.Errorf("local image inserted without an uploader: %s", jmar(r))
+		}
+	}
+	if got, want := insertedText(requests), "\nBefore  after"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+}
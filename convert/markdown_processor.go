@@ -4,26 +4,114 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/text"
 	"google.golang.org/api/docs/v1"
 )
 
 var slowdown = true
 
+// DocConverter writes Markdown into a Google Doc.
+type DocConverter struct {
+	// CodeFont is the font family code blocks and code spans are set in.
+	CodeFont string
+	// CodeBackground, if set, is the background color of code block
+	// paragraphs.
+	CodeBackground *docs.Color
+	// LinkBaseURL, if set, is the URL relative link destinations are
+	// resolved against.
+	LinkBaseURL string
+	// ImageUploader publishes local images so that Docs can fetch them. Local
+	// images are skipped, with a warning, without one.
+	ImageUploader ImageUploader
+	// ImageBaseDir is the directory relative local image paths are resolved
+	// against.
+	ImageBaseDir string
+	// MaxImageWidth is the largest width, in points, images are inserted at.
+	MaxImageWidth float64
+	// HTTPClient is the client remote images are fetched with to find their
+	// size. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// PageBreakMarker is the block written in place of a page break. Blocks
+	// consisting only of it are inserted as page breaks.
+	PageBreakMarker string
+	// SectionBreakMarker is the block written in place of a section break.
+	// Blocks consisting only of it are inserted as section breaks.
+	SectionBreakMarker string
+	// TOCMarker is the block a table of contents is generated in place of.
+	// The table of contents is a list of links to the document's headings.
+	TOCMarker string
+	// TabID, if set, is the ID of the tab the Markdown is written into.
+	// Otherwise it is written into the first tab.
+	TabID string
+}
+
+func NewDocConverter() *DocConverter {
+	return &DocConverter{
+		CodeFont:           "Roboto Mono",
+		PageBreakMarker:    DefaultPageBreakMarker,
+		SectionBreakMarker: DefaultSectionBreakMarker,
+		TOCMarker:          DefaultTOCMarker,
+		// The width of a letter page with one inch margins.
+		MaxImageWidth: 468,
+	}
+}
+
+// MarkdownToDoc writes mdContent into gdoc using the default DocConverter.
 func MarkdownToDoc(ctx context.Context, docsService DocumentService, parser MarkdownParser, gdoc *docs.Document, mdContent []byte) error {
+	return NewDocConverter().MarkdownToDoc(ctx, docsService, parser, gdoc, mdContent)
+}
+
+func (dc *DocConverter) MarkdownToDoc(ctx context.Context, docsService DocumentService, parser MarkdownParser, gdoc *docs.Document, mdContent []byte) error {
+	fm, mdContent, err := SplitFrontMatter(mdContent)
+	if err != nil {
+		return err
+	}
+	if fm.Styles != nil {
+		if dc, err = dc.withStyles(*fm.Styles); err != nil {
+			return err
+		}
+	}
+	if dc.LinkBaseURL != "" {
+		if _, err := url.Parse(dc.LinkBaseURL); err != nil {
+			return fmt.Errorf("invalid link base URL: %w", err)
+		}
+	}
 	doc := parser.Parse(text.NewReader(mdContent))
 
 	updates := []*docs.Request{}
+	// styles are applied once all text has been inserted, so that text
+	// inserted after a styled range does not inherit its style.
+	styles := []*docs.Request{}
 	index := int64(1)
 	styleStart := int64(1)
-	printLastUpdate := func() {
-		return
-		j, _ := json.Marshal(updates[len(updates)-1])
-		fmt.Println(len(updates), string(j), index)
+	// starts records the index at which the content of a node begins.
+	starts := map[ast.Node]int64{}
+	// segmentTexts is the text to insert into segments created by updates.
+	var segmentTexts []segmentText
+	footnotes := footnoteTexts(doc, mdContent)
+	headings := markdownHeadings(doc, mdContent)
+	// headingIndexes maps heading anchors to the position of their heading.
+	headingIndexes := map[string]int{}
+	for i, h := range headings {
+		if _, ok := headingIndexes[h.anchor]; h.anchor != "" && !ok {
+			headingIndexes[h.anchor] = i
+		}
 	}
+	// headingLinks are linked to their headings once the headings are
+	// written.
+	var headingLinks []headingLink
+	// emptyParagraph is set when the document has an empty paragraph at index
+	// the next block can be written into, as Docs follows tables and breaks
+	// with one.
+	emptyParagraph := false
 
 	addText := func(text string) *docs.Request {
 		update := &docs.Request{
@@ -34,36 +122,218 @@ func MarkdownToDoc(ctx context.Context, docsService DocumentService, parser Mark
 				},
 			},
 		}
-		index += int64(len(text))
+		index += utf16Len(text)
 		return update
 	}
 
-	performUpdate := func(update *docs.Request) error {
+	performUpdate := func(update *docs.Request) (*docs.Response, error) {
 		fmt.Println("performing update", jmar(update))
 		time.Sleep(2000 * time.Millisecond)
-		_, err := docsService.DoBatchUpdate(gdoc.DocumentId, &docs.BatchUpdateDocumentRequest{
+		resp, err := docsService.DoBatchUpdate(gdoc.DocumentId, &docs.BatchUpdateDocumentRequest{
 			Requests: []*docs.Request{update},
 		})
 
 		if err != nil {
-			return fmt.Errorf("unable to perform update: %w", err)
+			return nil, fmt.Errorf("unable to perform update: %w", err)
 		}
-		return nil
+		return replyAt(resp.Replies, 0), nil
+	}
+	// applyUpdates sends updates to the document and returns the replies to
+	// them.
+	applyUpdates := func(updates []*docs.Request) ([]*docs.Response, error) {
+		if dc.TabID != "" {
+			setTabID(updates, dc.TabID)
+		}
+		if slowdown {
+			replies := make([]*docs.Response, len(updates))
+			for i, update := range updates {
+				reply, err := performUpdate(update)
+				if err != nil {
+					return nil, err
+				}
+				replies[i] = reply
+			}
+			return replies, nil
+		}
+		resp, err := docsService.DoBatchUpdate(gdoc.DocumentId, &docs.BatchUpdateDocumentRequest{
+			Requests: updates,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Batch update failed: %w", err)
+		}
+		if resp.HTTPStatusCode != 200 {
+			return nil, fmt.Errorf("Batch update failed: %d - %v", resp.HTTPStatusCode, resp)
+		}
+		return resp.Replies, nil
 	}
 	addUpdate := func(update *docs.Request) {
 		updates = append(updates, update)
-		printLastUpdate()
 	}
-	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+	// startBlock starts a new paragraph for the next block.
+	startBlock := func() {
+		if emptyParagraph {
+			emptyParagraph = false
+			return
+		}
+		addUpdate(addText("\n"))
+	}
+	addStyle := func(update *docs.Request) {
+		styles = append(styles, update)
+	}
+	// addTextStyle styles the text inserted since start.
+	addTextStyle := func(start int64, style *docs.TextStyle, fields string) {
+		if index <= start {
+			return
+		}
+		addStyle(&docs.Request{
+			UpdateTextStyle: &docs.UpdateTextStyleRequest{
+				TextStyle: style,
+				Range: &docs.Range{
+					StartIndex: start,
+					EndIndex:   index,
+				},
+				Fields: fields,
+			},
+		})
+	}
+	// setSegmentText replaces the content of an existing header or footer,
+	// or creates one with the given request.
+	setSegmentText := func(id string, content []*docs.StructuralElement, create *docs.Request, text string) {
+		if text == "" {
+			return
+		}
+		if id == "" {
+			addUpdate(create)
+			segmentTexts = append(segmentTexts, segmentText{len(updates) - 1, text})
+			return
+		}
+		// Keep the newline every segment ends with.
+		if end := segmentEnd(content) - 1; end > 0 {
+			addUpdate(&docs.Request{
+				DeleteContentRange: &docs.DeleteContentRangeRequest{
+					Range: &docs.Range{
+						SegmentId:       id,
+						StartIndex:      0,
+						EndIndex:        end,
+						ForceSendFields: []string{"StartIndex"},
+					},
+				},
+			})
+		}
+		addUpdate(&docs.Request{
+			InsertText: &docs.InsertTextRequest{
+				Text: text,
+				EndOfSegmentLocation: &docs.EndOfSegmentLocation{
+					SegmentId: id,
+				},
+			},
+		})
+	}
+	style := gdoc.DocumentStyle
+	if style == nil {
+		style = &docs.DocumentStyle{}
+	}
+	setSegmentText(style.DefaultHeaderId, gdoc.Headers[style.DefaultHeaderId].Content, &docs.Request{
+		CreateHeader: &docs.CreateHeaderRequest{Type: "DEFAULT"},
+	}, fm.Header)
+	setSegmentText(style.DefaultFooterId, gdoc.Footers[style.DefaultFooterId].Content, &docs.Request{
+		CreateFooter: &docs.CreateFooterRequest{Type: "DEFAULT"},
+	}, fm.Footer)
+
+	err = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
 		fmt.Println("node", node.Kind(), entering)
-		time.Sleep(150 * time.Millisecond)
+		if slowdown {
+			time.Sleep(150 * time.Millisecond)
+		}
+		if dc.isTOCMarker(node, mdContent) {
+			if !entering || len(headings) == 0 {
+				return ast.WalkSkipChildren, nil
+			}
+			var tocStart int64
+			for i, heading := range headings {
+				startBlock()
+				if i == 0 {
+					tocStart = index
+				}
+				link := headingLink{start: index, heading: i}
+				if heading.text != "" {
+					addUpdate(addText(heading.text))
+				}
+				link.end = index
+				headingLinks = append(headingLinks, link)
+			}
+			addUpdate(&docs.Request{
+				UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+					ParagraphStyle: &docs.ParagraphStyle{
+						NamedStyleType: "NORMAL_TEXT",
+					},
+					Range: &docs.Range{
+						StartIndex: tocStart,
+						EndIndex:   index,
+					},
+					Fields: "namedStyleType",
+				},
+			})
+			addStyle(&docs.Request{
+				CreateParagraphBullets: &docs.CreateParagraphBulletsRequest{
+					BulletPreset: "BULLET_DISC_CIRCLE_SQUARE",
+					Range: &docs.Range{
+						StartIndex: tocStart,
+						EndIndex:   index,
+					},
+				},
+			})
+			return ast.WalkSkipChildren, nil
+		}
+		if kind := dc.blockBreak(node, mdContent); kind != noBreak {
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			switch kind {
+			case horizontalRule:
+				startBlock()
+				addStyle(&docs.Request{
+					UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+						ParagraphStyle: horizontalRuleStyle(),
+						Range: &docs.Range{
+							StartIndex: index,
+							EndIndex:   index + 1,
+						},
+						Fields: "namedStyleType,borderBottom",
+					},
+				})
+			case pageBreak:
+				startBlock()
+				addUpdate(&docs.Request{
+					InsertPageBreak: &docs.InsertPageBreakRequest{
+						Location: &docs.Location{Index: index},
+					},
+				})
+				// The page break is followed by a newline, leaving the
+				// paragraph after it empty.
+				index += 2
+				emptyParagraph = true
+			case sectionBreak:
+				addUpdate(&docs.Request{
+					InsertSectionBreak: &docs.InsertSectionBreakRequest{
+						SectionType: "NEXT_PAGE",
+						Location:    &docs.Location{Index: index},
+					},
+				})
+				// The section break is preceded by a newline ending the
+				// previous paragraph, and followed by an empty one.
+				index += 2
+				emptyParagraph = true
+			}
+			return ast.WalkSkipChildren, nil
+		}
 		switch n := node.(type) {
 		case *ast.Document:
 		case *ast.Heading:
 			fmt.Println("heading", n.Level, entering)
 			if entering {
 				if index > 1 {
-					addUpdate(addText("\n"))
+					startBlock()
 				}
 				styleStart = index
 			} else {
@@ -83,7 +353,7 @@ func MarkdownToDoc(ctx context.Context, docsService DocumentService, parser Mark
 		case *ast.Paragraph:
 			fmt.Println("paragraph", entering)
 			if entering {
-				addUpdate(addText("\n"))
+				startBlock()
 				styleStart = index
 			} else {
 				addUpdate(&docs.Request{
@@ -104,31 +374,56 @@ func MarkdownToDoc(ctx context.Context, docsService DocumentService, parser Mark
 			if entering {
 				return ast.WalkContinue, nil
 			}
-			textLen := len(n.Segment.Value(mdContent))
-			if textLen == 0 {
+			text := markdownText(n, mdContent)
+			switch {
+			case n.HardLineBreak():
+				// Docs writes line breaks within a paragraph as vertical
+				// tabs.
+				text += "\v"
+			case n.SoftLineBreak():
+				text += " "
+			}
+			if len(text) == 0 {
 				return ast.WalkContinue, nil
 			}
-			addUpdate(&docs.Request{
-				InsertText: &docs.InsertTextRequest{
-					Text: string(n.Segment.Value(mdContent)),
-					Location: &docs.Location{
-						Index: int64(index),
+			addUpdate(addText(text))
+		case *ast.Emphasis:
+			if entering {
+				starts[n] = index
+			} else if n.Level >= 2 {
+				addTextStyle(starts[n], &docs.TextStyle{Bold: true}, "bold")
+			} else {
+				addTextStyle(starts[n], &docs.TextStyle{Italic: true}, "italic")
+			}
+		case *extast.Strikethrough:
+			if entering {
+				starts[n] = index
+			} else {
+				addTextStyle(starts[n], &docs.TextStyle{Strikethrough: true}, "strikethrough")
+			}
+		case *ast.CodeSpan:
+			if entering {
+				starts[n] = index
+			} else {
+				addTextStyle(starts[n], &docs.TextStyle{
+					WeightedFontFamily: &docs.WeightedFontFamily{
+						FontFamily: dc.CodeFont,
 					},
-				},
-			})
-			index += int64(len(n.Segment.Value(mdContent)))
+				}, "weightedFontFamily")
+			}
 		case *ast.List:
 			fmt.Println("list", entering)
-			if entering {
-				//addUpdate(addText("\n"))
-				styleStart = index
-			} else {
-				addUpdate(&docs.Request{
+			if !entering && n.FirstChild() != nil {
+				preset := "BULLET_DISC_CIRCLE_SQUARE"
+				if isTaskList(n) {
+					preset = "BULLET_CHECKBOX"
+				}
+				addStyle(&docs.Request{
 					CreateParagraphBullets: &docs.CreateParagraphBulletsRequest{
-						BulletPreset: "BULLET_DISC_CIRCLE_SQUARE",
+						BulletPreset: preset,
 						Range: &docs.Range{
-							StartIndex: styleStart,
-							EndIndex:   index - 1,
+							StartIndex: starts[n.FirstChild()],
+							EndIndex:   index,
 						},
 					},
 				})
@@ -136,27 +431,182 @@ func MarkdownToDoc(ctx context.Context, docsService DocumentService, parser Mark
 		case *ast.ListItem:
 			fmt.Println("list item", entering)
 			if entering {
-				addUpdate(addText("\n"))
+				startBlock()
+				starts[n] = index
+			} else if box := taskCheckBox(n); box != nil && box.IsChecked {
+				// Docs marks checked items by striking through their text.
+				addTextStyle(starts[n], &docs.TextStyle{Strikethrough: true}, "strikethrough")
 			}
-		case *ast.Link:
-			fmt.Println("link", entering)
-			if entering {
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if !entering {
 				return ast.WalkContinue, nil
 			}
+			if index > 1 {
+				startBlock()
+			}
+			start := index
+			code := codeBlockText(n, mdContent)
+			if code == "" {
+				return ast.WalkSkipChildren, nil
+			}
+			addUpdate(addText(code))
 			addUpdate(&docs.Request{
-				UpdateTextStyle: &docs.UpdateTextStyleRequest{
-					TextStyle: &docs.TextStyle{
-						Link: &docs.Link{
-							Url: string(n.Destination),
-						},
+				UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+					ParagraphStyle: &docs.ParagraphStyle{
+						NamedStyleType: "NORMAL_TEXT",
 					},
 					Range: &docs.Range{
-						StartIndex: index - int64(len(n.Destination)),
-						EndIndex:   index - 1,
+						StartIndex: start,
+						EndIndex:   index,
+					},
+					Fields: "namedStyleType",
+				},
+			})
+			addTextStyle(start, &docs.TextStyle{
+				WeightedFontFamily: &docs.WeightedFontFamily{
+					FontFamily: dc.CodeFont,
+				},
+			}, "weightedFontFamily")
+			if dc.CodeBackground != nil {
+				addStyle(&docs.Request{
+					UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+						ParagraphStyle: &docs.ParagraphStyle{
+							Shading: &docs.Shading{
+								BackgroundColor: &docs.OptionalColor{
+									Color: dc.CodeBackground,
+								},
+							},
+						},
+						Range: &docs.Range{
+							StartIndex: start,
+							EndIndex:   index,
+						},
+						Fields: "shading.backgroundColor",
+					},
+				})
+			}
+			return ast.WalkSkipChildren, nil
+		case *extast.Table:
+			// Docs lays out a table as a start marker followed by each row
+			// and each cell's start marker and paragraphs. index is moved
+			// through that structure so cell content is written in place.
+			if entering {
+				addUpdate(&docs.Request{
+					InsertTable: &docs.InsertTableRequest{
+						Rows:    int64(n.ChildCount()),
+						Columns: int64(len(n.Alignments)),
+						Location: &docs.Location{
+							Index: index,
+						},
+					},
+				})
+				// Docs inserts a newline before the table.
+				index += 2
+				emptyParagraph = false
+			} else {
+				emptyParagraph = true
+			}
+		case *extast.TableHeader, *extast.TableRow:
+			if entering {
+				index++
+			} else if table, ok := n.Parent().(*extast.Table); ok {
+				// Skip any cells missing from the row.
+				index += 2 * int64(len(table.Alignments)-n.ChildCount())
+			}
+		case *extast.TableCell:
+			if entering {
+				index++
+				starts[n] = index
+				return ast.WalkContinue, nil
+			}
+			if _, ok := n.Parent().(*extast.TableHeader); ok {
+				addTextStyle(starts[n], &docs.TextStyle{Bold: true}, "bold")
+			}
+			if alignment := paragraphAlignment(n.Alignment); alignment != "" {
+				addStyle(&docs.Request{
+					UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+						ParagraphStyle: &docs.ParagraphStyle{
+							Alignment: alignment,
+						},
+						Range: &docs.Range{
+							StartIndex: starts[n],
+							EndIndex:   index + 1,
+						},
+						Fields: "alignment",
 					},
-					Fields: "link",
+				})
+			}
+			// Skip the newline ending the cell's paragraph.
+			index++
+		case *extast.TaskCheckBox:
+		case *ast.Link:
+			fmt.Println("link", entering)
+			// Reference links are resolved by the parser. Docs links have no
+			// title, so link titles are dropped.
+			if entering {
+				starts[n] = index
+				return ast.WalkContinue, nil
+			}
+			if anchor, ok := strings.CutPrefix(string(n.Destination), "#"); ok {
+				if i, ok := headingIndexes[anchor]; ok {
+					headingLinks = append(headingLinks, headingLink{start: starts[n], end: index, heading: i})
+					return ast.WalkContinue, nil
+				}
+				if id, ok := strings.CutPrefix(anchor, bookmarkAnchorPrefix); ok {
+					addTextStyle(starts[n], &docs.TextStyle{
+						Link: &docs.Link{BookmarkId: "id." + id},
+					}, "link")
+					return ast.WalkContinue, nil
+				}
+			}
+			addTextStyle(starts[n], &docs.TextStyle{
+				Link: &docs.Link{
+					Url: dc.linkURL(string(n.Destination)),
+				},
+			}, "link")
+		case *ast.AutoLink:
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			start := index
+			addUpdate(addText(string(n.Label(mdContent))))
+			addTextStyle(start, &docs.TextStyle{
+				Link: &docs.Link{
+					Url: dc.linkURL(string(n.URL(mdContent))),
+				},
+			}, "link")
+		case *ast.Image:
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			parseImageAttributes(n, mdContent)
+			image, err := dc.imageRequest(ctx, n)
+			if err != nil {
+				return ast.WalkStop, err
+			}
+			if image == nil {
+				return ast.WalkSkipChildren, nil
+			}
+			image.Location = &docs.Location{Index: index}
+			addUpdate(&docs.Request{InsertInlineImage: image})
+			index++
+			// Docs images have no alt text to write the children into.
+			return ast.WalkSkipChildren, nil
+		case *extast.FootnoteLink:
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			addUpdate(&docs.Request{
+				CreateFootnote: &docs.CreateFootnoteRequest{
+					Location: &docs.Location{Index: index},
 				},
 			})
+			segmentTexts = append(segmentTexts, segmentText{len(updates) - 1, footnotes[n.Index]})
+			// The footnote reference takes up one index.
+			index++
+		case *extast.FootnoteList:
+			// Footnote content is written into the footnotes themselves.
+			return ast.WalkSkipChildren, nil
 		case *ast.TextBlock:
 			fmt.Println("text block", entering)
 			if entering {
@@ -167,27 +617,228 @@ func MarkdownToDoc(ctx context.Context, docsService DocumentService, parser Mark
 		}
 		return ast.WalkContinue, nil
 	})
+	if err != nil {
+		return err
+	}
+	updates = append(updates, styles...)
 
-	if slowdown {
-		for _, update := range updates {
-			if err := performUpdate(update); err != nil {
-				return err
-			}
+	replies, err := applyUpdates(updates)
+	if err != nil {
+		return err
+	}
+	// Text for segments created by the updates can only be inserted once
+	// their IDs are known.
+	var segmentUpdates []*docs.Request
+	for _, st := range segmentTexts {
+		id := segmentID(replyAt(replies, st.request))
+		if id == "" {
+			return fmt.Errorf("no segment ID in reply to %s", jmar(updates[st.request]))
 		}
-	} else {
-		resp, err := docsService.DoBatchUpdate(gdoc.DocumentId, &docs.BatchUpdateDocumentRequest{
-			Requests: updates,
+		if st.text == "" {
+			continue
+		}
+		segmentUpdates = append(segmentUpdates, &docs.Request{
+			InsertText: &docs.InsertTextRequest{
+				Text: st.text,
+				EndOfSegmentLocation: &docs.EndOfSegmentLocation{
+					SegmentId: id,
+				},
+			},
 		})
+	}
+	if len(segmentUpdates) > 0 {
+		if _, err := applyUpdates(segmentUpdates); err != nil {
+			return err
+		}
+	}
+	// Headings are only given IDs to link to once they are written.
+	if len(headingLinks) > 0 {
+		written, err := docsService.GetDocument(gdoc.DocumentId)
 		if err != nil {
-			return fmt.Errorf("Batch update failed: %w", err)
+			return fmt.Errorf("unable to retrieve document: %w", err)
 		}
-		if resp.HTTPStatusCode != 200 {
-			return fmt.Errorf("Batch update failed: %d - %v", resp.HTTPStatusCode, resp)
+		links, err := headingLinkRequests(tabContent(written, dc.TabID), headingLinks, index)
+		if err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			if _, err := applyUpdates(links); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// segmentText is text to be inserted into a segment, such as a footnote or
+// header, created by the request at the given position.
+type segmentText struct {
+	request int
+	text    string
+}
+
+// segmentID returns the ID of the segment created by a request.
+func segmentID(reply *docs.Response) string {
+	switch {
+	case reply == nil:
+		return ""
+	case reply.CreateFootnote != nil:
+		return reply.CreateFootnote.FootnoteId
+	case reply.CreateHeader != nil:
+		return reply.CreateHeader.HeaderId
+	case reply.CreateFooter != nil:
+		return reply.CreateFooter.FooterId
+	}
+	return ""
+}
+
+// segmentEnd returns the end index of a segment's content.
+func segmentEnd(content []*docs.StructuralElement) int64 {
+	if len(content) == 0 {
+		return 0
+	}
+	return content[len(content)-1].EndIndex
+}
+
+// footnoteTexts returns the plain text of each footnote in doc, keyed by
+// footnote index.
+func footnoteTexts(doc ast.Node, source []byte) map[int]string {
+	texts := map[int]string{}
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fn, ok := node.(*extast.Footnote); ok && entering {
+			texts[fn.Index] = plainText(fn, source)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return texts
+}
+
+// plainText returns the text of a node and its descendants, with blocks
+// separated by newlines.
+func plainText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(n, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := node.(type) {
+		case *ast.Text:
+			b.WriteString(markdownText(node, source))
+			switch {
+			case node.HardLineBreak():
+				b.WriteString("\v")
+			case node.SoftLineBreak():
+				b.WriteString(" ")
+			}
+		case *ast.String:
+			b.Write(node.Value)
+		default:
+			if node != n && node.Type() == ast.TypeBlock && node.PreviousSibling() != nil {
+				b.WriteString("\n")
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// replyAt returns the reply to the i'th request, if any.
+func replyAt(replies []*docs.Response, i int) *docs.Response {
+	if i >= len(replies) {
+		return nil
+	}
+	return replies[i]
+}
+
+// withStyles returns a copy of dc with options overridden by styles.
+func (dc *DocConverter) withStyles(styles StyleOverrides) (*DocConverter, error) {
+	c := *dc
+	if styles.CodeFont != "" {
+		c.CodeFont = styles.CodeFont
+	}
+	if styles.CodeBackground != "" {
+		color, err := parseHexColor(styles.CodeBackground)
+		if err != nil {
+			return nil, fmt.Errorf("invalid code background: %w", err)
+		}
+		c.CodeBackground = color
+	}
+	if styles.LinkBaseURL != "" {
+		c.LinkBaseURL = styles.LinkBaseURL
+	}
+	return &c, nil
+}
+
+// linkURL returns the URL a link destination points to, resolving relative
+// destinations against LinkBaseURL.
+func (dc *DocConverter) linkURL(dest string) string {
+	if dc.LinkBaseURL == "" || strings.HasPrefix(dest, "#") {
+		return dest
+	}
+	base, err := url.Parse(dc.LinkBaseURL)
+	if err != nil {
+		return dest
+	}
+	ref, err := url.Parse(dest)
+	if err != nil || ref.IsAbs() {
+		return dest
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// paragraphAlignment returns the Docs paragraph alignment for a table column
+// alignment, or "" if the column is not aligned.
+func paragraphAlignment(a extast.Alignment) string {
+	switch a {
+	case extast.AlignLeft:
+		return "START"
+	case extast.AlignCenter:
+		return "CENTER"
+	case extast.AlignRight:
+		return "END"
+	}
+	return ""
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit Docs
+// indexes are measured in.
+func utf16Len(s string) int64 {
+	return int64(len(utf16.Encode([]rune(s))))
+}
+
+// codeBlockText returns the content of a code block without its trailing
+// newline.
+func codeBlockText(n ast.Node, source []byte) string {
+	var code []byte
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code = append(code, line.Value(source)...)
+	}
+	return strings.TrimSuffix(string(code), "\n")
+}
+
+// taskCheckBox returns the GFM task list checkbox of a list item, if any.
+func taskCheckBox(item *ast.ListItem) *extast.TaskCheckBox {
+	block := item.FirstChild()
+	if block == nil {
+		return nil
+	}
+	box, _ := block.FirstChild().(*extast.TaskCheckBox)
+	return box
+}
+
+// isTaskList reports whether any item of a list is a task list item.
+func isTaskList(list *ast.List) bool {
+	for c := list.FirstChild(); c != nil; c = c.NextSibling() {
+		if item, ok := c.(*ast.ListItem); ok && taskCheckBox(item) != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func jmar(v interface{}) string {
 	j, _ := json.MarshalIndent(v, "", "  ")
 	return string(j)
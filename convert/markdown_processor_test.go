@@ -0,0 +1,538 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// recordingDocsService is a DocumentService that records the requests sent to
+// it instead of calling the Docs API. Segments created by requests are given
+// sequential IDs.
+type recordingDocsService struct {
+	requests []*docs.Request
+	segments int
+	// document is returned by GetDocument.
+	document *docs.Document
+}
+
+var _ DocumentService = (*recordingDocsService)(nil)
+
+func (r *recordingDocsService) DoBatchUpdate(documentId string, req *docs.BatchUpdateDocumentRequest) (*docs.BatchUpdateDocumentResponse, error) {
+	r.requests = append(r.requests, req.Requests...)
+	var replies []*docs.Response
+	for _, request := range req.Requests {
+		reply := &docs.Response{}
+		switch {
+		case request.CreateFootnote != nil:
+			r.segments++
+			reply.CreateFootnote = &docs.CreateFootnoteResponse{FootnoteId: fmt.Sprintf("footnote%d", r.segments)}
+		case request.CreateHeader != nil:
+			r.segments++
+			reply.CreateHeader = &docs.CreateHeaderResponse{HeaderId: fmt.Sprintf("header%d", r.segments)}
+		case request.CreateFooter != nil:
+			r.segments++
+			reply.CreateFooter = &docs.CreateFooterResponse{FooterId: fmt.Sprintf("footer%d", r.segments)}
+		}
+		replies = append(replies, reply)
+	}
+	return &docs.BatchUpdateDocumentResponse{
+		DocumentId:     documentId,
+		Replies:        replies,
+		ServerResponse: googleapi.ServerResponse{HTTPStatusCode: 200},
+	}, nil
+}
+
+func (r *recordingDocsService) GetDocument(documentId string) (*docs.Document, error) {
+	if r.document == nil {
+		return &docs.Document{DocumentId: documentId}, nil
+	}
+	return r.document, nil
+}
+
+// markdownRequests converts md with dc and returns the requests that would be
+// sent to the Docs API.
+func markdownRequests(t *testing.T, dc *DocConverter, md string) []*docs.Request {
+	t.Helper()
+	return documentRequests(t, dc, &docs.Document{DocumentId: "doc"}, md)
+}
+
+// documentRequests converts md into gdoc with dc and returns the requests
+// that would be sent to the Docs API.
+func documentRequests(t *testing.T, dc *DocConverter, gdoc *docs.Document, md string) []*docs.Request {
+	t.Helper()
+	slowdown = false
+	svc := &recordingDocsService{}
+	if err := dc.MarkdownToDoc(context.Background(), svc, NewMarkdownParser(), gdoc, []byte(md)); err != nil {
+		t.Fatalf("MarkdownToDoc: %v", err)
+	}
+	return svc.requests
+}
+
+// insertedText returns the text inserted by requests, in the order inserted.
+func insertedText(requests []*docs.Request) string {
+	var s string
+	for _, r := range requests {
+		if r.InsertText != nil {
+			s += r.InsertText.Text
+		}
+	}
+	return s
+}
+
+func TestMarkdownToDocTaskList(t *testing.T) {
+	requests := markdownRequests(t, NewDocConverter(), "Todo\n\n- [ ] open\n- [x] done\n")
+
+	if got, want := insertedText(requests), "\nTodo\nopen\ndone"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+	var bullets []*docs.CreateParagraphBulletsRequest
+	for _, r := range requests {
+		if r.CreateParagraphBullets != nil {
+			bullets = append(bullets, r.CreateParagraphBullets)
+		}
+	}
+	wantBullets := []*docs.CreateParagraphBulletsRequest{{
+		BulletPreset: "BULLET_CHECKBOX",
+		Range:        &docs.Range{StartIndex: 7, EndIndex: 16},
+	}}
+	if diff := cmp.Diff(wantBullets, bullets); diff != "" {
+		t.Errorf("bullets mismatch (-want +got):\n%s", diff)
+	}
+	wantStyles := []*docs.UpdateTextStyleRequest{{
+		TextStyle: &docs.TextStyle{Strikethrough: true},
+		Range:     &docs.Range{StartIndex: 12, EndIndex: 16},
+		Fields:    "strikethrough",
+	}}
+	if diff := cmp.Diff(wantStyles, textStyles(requests)); diff != "" {
+		t.Errorf("text styles mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocCodeBlock(t *testing.T) {
+	dc := NewDocConverter()
+	dc.CodeFont = "Courier New"
+	dc.CodeBackground = &docs.Color{RgbColor: &docs.RgbColor{Red: 0.9, Green: 0.9, Blue: 0.9}}
+	requests := markdownRequests(t, dc, "# Example\n\n```go\nif x {\n\n    return\n}\n```\n")
+
+	if got, want := insertedText(requests), "Example\nif x {\n\n    return\n}"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+	codeRange := &docs.Range{StartIndex: 9, EndIndex: 29}
+	want := []*docs.Request{
+		{
+			UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+				ParagraphStyle: &docs.ParagraphStyle{NamedStyleType: "NORMAL_TEXT"},
+				Range:          codeRange,
+				Fields:         "namedStyleType",
+			},
+		},
+		{
+			UpdateTextStyle: &docs.UpdateTextStyleRequest{
+				TextStyle: &docs.TextStyle{
+					WeightedFontFamily: &docs.WeightedFontFamily{FontFamily: "Courier New"},
+				},
+				Range:  codeRange,
+				Fields: "weightedFontFamily",
+			},
+		},
+		{
+			UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+				ParagraphStyle: &docs.ParagraphStyle{
+					Shading: &docs.Shading{
+						BackgroundColor: &docs.OptionalColor{Color: dc.CodeBackground},
+					},
+				},
+				Range:  codeRange,
+				Fields: "shading.backgroundColor",
+			},
+		},
+	}
+	var got []*docs.Request
+	for _, r := range requests {
+		if r.InsertText == nil && r.UpdateParagraphStyle != nil && r.UpdateParagraphStyle.Range.StartIndex == 9 || r.UpdateTextStyle != nil {
+			got = append(got, r)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("code block styles mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// textStyles returns the text style requests among requests.
+func textStyles(requests []*docs.Request) []*docs.UpdateTextStyleRequest {
+	var styles []*docs.UpdateTextStyleRequest
+	for _, r := range requests {
+		if r.UpdateTextStyle != nil {
+			styles = append(styles, r.UpdateTextStyle)
+		}
+	}
+	return styles
+}
+
+func TestMarkdownToDocInlineStyles(t *testing.T) {
+	requests := markdownRequests(t, NewDocConverter(), "é *it* **bold *both*** ~~gone~~ `code`")
+
+	if got, want := insertedText(requests), "\né it bold both gone code"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+	want := []*docs.UpdateTextStyleRequest{
+		{
+			TextStyle: &docs.TextStyle{Italic: true},
+			Range:     &docs.Range{StartIndex: 4, EndIndex: 6},
+			Fields:    "italic",
+		},
+		{
+			TextStyle: &docs.TextStyle{Italic: true},
+			Range:     &docs.Range{StartIndex: 12, EndIndex: 16},
+			Fields:    "italic",
+		},
+		{
+			TextStyle: &docs.TextStyle{Bold: true},
+			Range:     &docs.Range{StartIndex: 7, EndIndex: 16},
+			Fields:    "bold",
+		},
+		{
+			TextStyle: &docs.TextStyle{Strikethrough: true},
+			Range:     &docs.Range{StartIndex: 17, EndIndex: 21},
+			Fields:    "strikethrough",
+		},
+		{
+			TextStyle: &docs.TextStyle{
+				WeightedFontFamily: &docs.WeightedFontFamily{FontFamily: "Roboto Mono"},
+			},
+			Range:  &docs.Range{StartIndex: 22, EndIndex: 26},
+			Fields: "weightedFontFamily",
+		},
+	}
+	if diff := cmp.Diff(want, textStyles(requests)); diff != "" {
+		t.Errorf("text styles mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocLinks(t *testing.T) {
+	dc := NewDocConverter()
+	dc.LinkBaseURL = "https://example.com/docs/"
+	md := "See [the *guide*](guide.md \"Guide\"), [ref][r], <https://go.dev> and [top](#top).\n\n[r]: https://ref.example.com\n"
+	requests := markdownRequests(t, dc, md)
+
+	if got, want := insertedText(requests), "\nSee the guide, ref, https://go.dev and top."; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+	var got []*docs.UpdateTextStyleRequest
+	for _, s := range textStyles(requests) {
+		if s.Fields == "link" {
+			got = append(got, s)
+		}
+	}
+	link := func(url string, start, end int64) *docs.UpdateTextStyleRequest {
+		return &docs.UpdateTextStyleRequest{
+			TextStyle: &docs.TextStyle{Link: &docs.Link{Url: url}},
+			Range:     &docs.Range{StartIndex: start, EndIndex: end},
+			Fields:    "link",
+		}
+	}
+	want := []*docs.UpdateTextStyleRequest{
+		link("https://example.com/docs/guide.md", 6, 15),
+		link("https://ref.example.com", 17, 20),
+		link("https://go.dev", 22, 36),
+		link("#top", 41, 44),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("links mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocTable(t *testing.T) {
+	requests := markdownRequests(t, NewDocConverter(), "Intro\n\n| A | B |\n|:--|--:|\n| 1 | 2 |\n\nAfter\n")
+
+	var got []*docs.Request
+	for _, r := range requests {
+		if r.UpdateParagraphStyle != nil && r.UpdateParagraphStyle.Fields == "namedStyleType" {
+			continue
+		}
+		got = append(got, r)
+	}
+	insert := func(text string, index int64) *docs.Request {
+		return &docs.Request{InsertText: &docs.InsertTextRequest{
+			Text:     text,
+			Location: &docs.Location{Index: index},
+		}}
+	}
+	bold := func(start, end int64) *docs.Request {
+		return &docs.Request{UpdateTextStyle: &docs.UpdateTextStyleRequest{
+			TextStyle: &docs.TextStyle{Bold: true},
+			Range:     &docs.Range{StartIndex: start, EndIndex: end},
+			Fields:    "bold",
+		}}
+	}
+	align := func(alignment string, start, end int64) *docs.Request {
+		return &docs.Request{UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+			ParagraphStyle: &docs.ParagraphStyle{Alignment: alignment},
+			Range:          &docs.Range{StartIndex: start, EndIndex: end},
+			Fields:         "alignment",
+		}}
+	}
+	want := []*docs.Request{
+		insert("\n", 1),
+		insert("Intro", 2),
+		{InsertTable: &docs.InsertTableRequest{
+			Rows:     2,
+			Columns:  2,
+			Location: &docs.Location{Index: 7},
+		}},
+		insert("A", 11),
+		insert("B", 14),
+		insert("1", 18),
+		insert("2", 21),
+		insert("After", 23),
+		bold(11, 12),
+		align("START", 11, 13),
+		bold(14, 15),
+		align("END", 14, 16),
+		align("START", 18, 20),
+		align("END", 21, 23),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocFootnotes(t *testing.T) {
+	md := "Claim[^a] and more[^b].\n\n[^a]: First source.\n[^b]: Second\n    source.\n\n    Details.\n"
+	requests := markdownRequests(t, NewDocConverter(), md)
+
+	want := []*docs.Request{
+		{InsertText: &docs.InsertTextRequest{Text: "\n", Location: &docs.Location{Index: 1}}},
+		{InsertText: &docs.InsertTextRequest{Text: "Claim", Location: &docs.Location{Index: 2}}},
+		{CreateFootnote: &docs.CreateFootnoteRequest{Location: &docs.Location{Index: 7}}},
+		{InsertText: &docs.InsertTextRequest{Text: " and more", Location: &docs.Location{Index: 8}}},
+		{CreateFootnote: &docs.CreateFootnoteRequest{Location: &docs.Location{Index: 17}}},
+		{InsertText: &docs.InsertTextRequest{Text: ".", Location: &docs.Location{Index: 18}}},
+		{InsertText: &docs.InsertTextRequest{
+			Text:                 "First source.",
+			EndOfSegmentLocation: &docs.EndOfSegmentLocation{SegmentId: "footnote1"},
+		}},
+		{InsertText: &docs.InsertTextRequest{
+			Text:                 "Second source.\nDetails.",
+			EndOfSegmentLocation: &docs.EndOfSegmentLocation{SegmentId: "footnote2"},
+		}},
+	}
+	var got []*docs.Request
+	for _, r := range requests {
+		if r.InsertText != nil || r.CreateFootnote != nil {
+			got = append(got, r)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocHeadersFooters(t *testing.T) {
+	gdoc := &docs.Document{
+		DocumentId:    "doc",
+		DocumentStyle: &docs.DocumentStyle{DefaultFooterId: "kix.footer"},
+		Footers: map[string]docs.Footer{
+			"kix.footer": {Content: []*docs.StructuralElement{
+				{StartIndex: 0, EndIndex: 4, Paragraph: &docs.Paragraph{}},
+			}},
+		},
+	}
+	requests := documentRequests(t, NewDocConverter(), gdoc, "---\nheader: Draft\nfooter: Page\n---\nBody\n")
+
+	want := []*docs.Request{
+		{CreateHeader: &docs.CreateHeaderRequest{Type: "DEFAULT"}},
+		{DeleteContentRange: &docs.DeleteContentRangeRequest{Range: &docs.Range{
+			SegmentId:       "kix.footer",
+			EndIndex:        3,
+			ForceSendFields: []string{"StartIndex"},
+		}}},
+		{InsertText: &docs.InsertTextRequest{
+			Text:                 "Page",
+			EndOfSegmentLocation: &docs.EndOfSegmentLocation{SegmentId: "kix.footer"},
+		}},
+		{InsertText: &docs.InsertTextRequest{Text: "\n", Location: &docs.Location{Index: 1}}},
+		{InsertText: &docs.InsertTextRequest{Text: "Body", Location: &docs.Location{Index: 2}}},
+		{InsertText: &docs.InsertTextRequest{
+			Text:                 "Draft",
+			EndOfSegmentLocation: &docs.EndOfSegmentLocation{SegmentId: "header1"},
+		}},
+	}
+	var got []*docs.Request
+	for _, r := range requests {
+		if r.UpdateParagraphStyle == nil {
+			got = append(got, r)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocFrontMatterStyles(t *testing.T) {
+	md := "---\ntitle: Example\nstyles:\n  code_font: Courier New\n  code_background: \"#ff0000\"\n---\n```\nx\n```\n"
+	requests := markdownRequests(t, NewDocConverter(), md)
+
+	var fonts []string
+	var backgrounds []*docs.Color
+	for _, r := range requests {
+		if r.UpdateTextStyle != nil && r.UpdateTextStyle.TextStyle.WeightedFontFamily != nil {
+			fonts = append(fonts, r.UpdateTextStyle.TextStyle.WeightedFontFamily.FontFamily)
+		}
+		if r.UpdateParagraphStyle != nil && r.UpdateParagraphStyle.ParagraphStyle.Shading != nil {
+			backgrounds = append(backgrounds, r.UpdateParagraphStyle.ParagraphStyle.Shading.BackgroundColor.Color)
+		}
+	}
+	if diff := cmp.Diff([]string{"Courier New"}, fonts); diff != "" {
+		t.Errorf("code fonts mismatch (-want +got):\n%s", diff)
+	}
+	wantBackgrounds := []*docs.Color{{RgbColor: &docs.RgbColor{Red: 1}}}
+	if diff := cmp.Diff(wantBackgrounds, backgrounds); diff != "" {
+		t.Errorf("code backgrounds mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocBreaks(t *testing.T) {
+	dc := NewDocConverter()
+	dc.PageBreakMarker = `\pagebreak`
+	requests := markdownRequests(t, dc, "One\n\n---\n\nTwo\n\n\\pagebreak\n\nThree\n\n<!-- sectionbreak -->\n\nFour\n")
+
+	if got, want := insertedText(requests), "\nOne\n\nTwo\nThreeFour"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+	var got []*docs.Request
+	for _, r := range requests {
+		if r.InsertPageBreak != nil || r.InsertSectionBreak != nil || r.UpdateParagraphStyle != nil && r.UpdateParagraphStyle.ParagraphStyle.BorderBottom != nil {
+			got = append(got, r)
+		}
+	}
+	want := []*docs.Request{
+		{
+			InsertPageBreak: &docs.InsertPageBreakRequest{
+				Location: &docs.Location{Index: 11},
+			},
+		},
+		{
+			InsertSectionBreak: &docs.InsertSectionBreakRequest{
+				SectionType: "NEXT_PAGE",
+				Location:    &docs.Location{Index: 18},
+			},
+		},
+		{
+			UpdateParagraphStyle: &docs.UpdateParagraphStyleRequest{
+				ParagraphStyle: horizontalRuleStyle(),
+				Range:          &docs.Range{StartIndex: 6, EndIndex: 7},
+				Fields:         "namedStyleType,borderBottom",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("break requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocLineBreaks(t *testing.T) {
+	requests := markdownRequests(t, NewDocConverter(), "One\\\nTwo  \nThree\nFour\n")
+	if got, want := insertedText(requests), "\nOne\vTwo\vThree Four"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+}
+
+func TestMarkdownToDocTableOfContents(t *testing.T) {
+	slowdown = false
+	heading := func(start int64, id string) *docs.StructuralElement {
+		return &docs.StructuralElement{
+			StartIndex: start,
+			Paragraph: &docs.Paragraph{
+				ParagraphStyle: &docs.ParagraphStyle{NamedStyleType: "HEADING_1", HeadingId: id},
+			},
+		}
+	}
+	svc := &recordingDocsService{
+		document: &docs.Document{Body: &docs.Body{Content: []*docs.StructuralElement{
+			heading(10, "h.one"),
+			heading(14, "h.two"),
+			// Headings after the written Markdown are not linked to.
+			heading(18, "h.existing"),
+		}}},
+	}
+	md := "[TOC]\n\n# One\n\n## Two\n"
+	if err := NewDocConverter().MarkdownToDoc(context.Background(), svc, NewMarkdownParser(), &docs.Document{DocumentId: "doc"}, []byte(md)); err != nil {
+		t.Fatalf("MarkdownToDoc: %v", err)
+	}
+
+	if got, want := insertedText(svc.requests), "\nOne\nTwo\nOne\nTwo"; got != want {
+		t.Errorf("inserted text = %q, want %q", got, want)
+	}
+	var bullets []*docs.CreateParagraphBulletsRequest
+	for _, r := range svc.requests {
+		if r.CreateParagraphBullets != nil {
+			bullets = append(bullets, r.CreateParagraphBullets)
+		}
+	}
+	wantBullets := []*docs.CreateParagraphBulletsRequest{{
+		BulletPreset: "BULLET_DISC_CIRCLE_SQUARE",
+		Range:        &docs.Range{StartIndex: 2, EndIndex: 9},
+	}}
+	if diff := cmp.Diff(wantBullets, bullets); diff != "" {
+		t.Errorf("bullets mismatch (-want +got):\n%s", diff)
+	}
+	wantLinks := []*docs.UpdateTextStyleRequest{
+		{
+			TextStyle: &docs.TextStyle{Link: &docs.Link{HeadingId: "h.one"}},
+			Range:     &docs.Range{StartIndex: 2, EndIndex: 5},
+			Fields:    "link",
+		},
+		{
+			TextStyle: &docs.TextStyle{Link: &docs.Link{HeadingId: "h.two"}},
+			Range:     &docs.Range{StartIndex: 6, EndIndex: 9},
+			Fields:    "link",
+		},
+	}
+	if diff := cmp.Diff(wantLinks, textStyles(svc.requests)); diff != "" {
+		t.Errorf("links mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkdownToDocInternalLinks(t *testing.T) {
+	slowdown = false
+	svc := &recordingDocsService{
+		document: &docs.Document{Body: &docs.Body{Content: []*docs.StructuralElement{{
+			StartIndex: 1,
+			Paragraph: &docs.Paragraph{
+				ParagraphStyle: &docs.ParagraphStyle{NamedStyleType: "HEADING_1", HeadingId: "h.intro"},
+			},
+		}}}},
+	}
+	md := "# Intro\n\nSee [intro](#intro) and [mark](#bookmark-abc) and [gone](#missing).\n"
+	if err := NewDocConverter().MarkdownToDoc(context.Background(), svc, NewMarkdownParser(), &docs.Document{DocumentId: "doc"}, []byte(md)); err != nil {
+		t.Fatalf("MarkdownToDoc: %v", err)
+	}
+
+	want := []*docs.UpdateTextStyleRequest{
+		{
+			TextStyle: &docs.TextStyle{Link: &docs.Link{BookmarkId: "id.abc"}},
+			Range:     &docs.Range{StartIndex: 21, EndIndex: 25},
+			Fields:    "link",
+		},
+		{
+			TextStyle: &docs.TextStyle{Link: &docs.Link{Url: "#missing"}},
+			Range:     &docs.Range{StartIndex: 30, EndIndex: 34},
+			Fields:    "link",
+		},
+		// Heading links are added once the heading has an ID.
+		{
+			TextStyle: &docs.TextStyle{Link: &docs.Link{HeadingId: "h.intro"}},
+			Range:     &docs.Range{StartIndex: 11, EndIndex: 16},
+			Fields:    "link",
+		},
+	}
+	if diff := cmp.Diff(want, textStyles(svc.requests)); diff != "" {
+		t.Errorf("links mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -80,7 +80,7 @@ This is a paragraph
 			convertedMD := string(convertedMDBytes)
 
 			if cmp.Diff(md, convertedMD) != "" {
-				t.Fatalf(cmp.Diff(md, convertedMD))
+				t.Fatal(cmp.Diff(md, convertedMD))
 			}
 		})
 	}
@@ -3,14 +3,19 @@
 package convert
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/text"
 	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
 )
 
 type DocumentService interface {
 	DoBatchUpdate(string, *docs.BatchUpdateDocumentRequest) (*docs.BatchUpdateDocumentResponse, error)
+	GetDocument(string) (*docs.Document, error)
 }
 
 type RealDocumentService struct {
@@ -21,6 +26,40 @@ func (r *RealDocumentService) DoBatchUpdate(documentId string, request *docs.Bat
 	return r.Documents.BatchUpdate(documentId, request).Do()
 }
 
+func (r *RealDocumentService) GetDocument(documentId string) (*docs.Document, error) {
+	return r.Documents.Get(documentId).IncludeTabsContent(true).Do()
+}
+
+// CommentService lists the comments on a Google Doc.
+type CommentService interface {
+	ListComments(documentId string) ([]*drive.Comment, error)
+}
+
+// DriveCommentService lists comments with the Drive API.
+type DriveCommentService struct {
+	*drive.Service
+}
+
+func (d *DriveCommentService) ListComments(documentId string) ([]*drive.Comment, error) {
+	var comments []*drive.Comment
+	err := d.Comments.List(documentId).
+		Fields("nextPageToken", "comments(id,author,content,quotedFileContent,resolved,deleted,replies(author,content,deleted))").
+		Pages(context.Background(), func(page *drive.CommentList) error {
+			comments = append(comments, page.Comments...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list comments: %w", err)
+	}
+	return comments, nil
+}
+
 type MarkdownParser interface {
 	Parse(text.Reader, ...parser.ParseOption) ast.Node
 }
+
+// ImageUploader publishes local image files at URIs that Google Docs can
+// fetch them from.
+type ImageUploader interface {
+	Upload(ctx context.Context, path string) (uri string, err error)
+}
@@ -0,0 +1,237 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/parser"
+	"google.golang.org/api/docs/v1"
+)
+
+// DocumentTabs returns the tabs of a document retrieved with its tabs'
+// content, with child tabs following their parent, in the order they are
+// shown.
+func DocumentTabs(doc *docs.Document) []*docs.Tab {
+	var tabs []*docs.Tab
+	var walk func([]*docs.Tab)
+	walk = func(ts []*docs.Tab) {
+		for _, t := range ts {
+			tabs = append(tabs, t)
+			walk(t.ChildTabs)
+		}
+	}
+	walk(doc.Tabs)
+	return tabs
+}
+
+// FindTab returns the tab of a document with the given ID, or failing that
+// the first with the given title. It returns nil if there is no such tab.
+func FindTab(doc *docs.Document, idOrTitle string) *docs.Tab {
+	tabs := DocumentTabs(doc)
+	for _, t := range tabs {
+		if t.TabProperties != nil && t.TabProperties.TabId == idOrTitle {
+			return t
+		}
+	}
+	for _, t := range tabs {
+		if t.TabProperties != nil && t.TabProperties.Title == idOrTitle {
+			return t
+		}
+	}
+	return nil
+}
+
+// TabDocument returns a document with the content of one of doc's tabs, so
+// that the tab can be converted like a document without tabs.
+func TabDocument(doc *docs.Document, tab *docs.Tab) *docs.Document {
+	d := &docs.Document{
+		DocumentId:          doc.DocumentId,
+		Title:               doc.Title,
+		RevisionId:          doc.RevisionId,
+		SuggestionsViewMode: doc.SuggestionsViewMode,
+	}
+	if t := tab.DocumentTab; t != nil {
+		d.Body = t.Body
+		d.DocumentStyle = t.DocumentStyle
+		d.Footers = t.Footers
+		d.Footnotes = t.Footnotes
+		d.Headers = t.Headers
+		d.InlineObjects = t.InlineObjects
+		d.Lists = t.Lists
+		d.NamedRanges = t.NamedRanges
+		d.NamedStyles = t.NamedStyles
+		d.PositionedObjects = t.PositionedObjects
+	}
+	return d
+}
+
+// hasTabContent reports whether a document was retrieved with its tabs'
+// content, which leaves its own body empty.
+func hasTabContent(doc *docs.Document) bool {
+	return doc.Body == nil && len(doc.Tabs) > 0
+}
+
+// tabContent returns the content of the tab with the given ID, or of the
+// first tab if tabID is empty, if doc was retrieved with its tabs' content.
+func tabContent(doc *docs.Document, tabID string) *docs.Document {
+	if !hasTabContent(doc) {
+		return doc
+	}
+	tab := doc.Tabs[0]
+	if tabID != "" {
+		if tab = FindTab(doc, tabID); tab == nil {
+			return &docs.Document{DocumentId: doc.DocumentId}
+		}
+	}
+	return TabDocument(doc, tab)
+}
+
+// TabAsMarkdown returns the Markdown for one tab of a document retrieved
+// with its tabs' content, headed by the tab's title.
+func (mc *MarkdownConverter) TabAsMarkdown(doc *docs.Document, tab *docs.Tab) ([]byte, error) {
+	mc.tab = tab.TabProperties
+	if mc.tab == nil {
+		mc.tab = &docs.TabProperties{}
+	}
+	mc.tabID = mc.tab.TabId
+	defer func() { mc.tab, mc.tabID = nil, "" }()
+	return mc.AsMarkdown(TabDocument(doc, tab))
+}
+
+// TabsAsMarkdown returns the Markdown for all the tabs of a document
+// retrieved with its tabs' content, as sections headed by their titles. The
+// front matter and headers and footers exported as front matter are those of
+// the document and its first tab. Comments are anchored in any of the tabs
+// and written after the last one, and footnotes and heading anchors are
+// numbered through all of them, so that links between tabs resolve.
+func (mc *MarkdownConverter) TabsAsMarkdown(doc *docs.Document) ([]byte, error) {
+	tabs := DocumentTabs(doc)
+	var md []string
+	var fm FrontMatter
+	if mc.FrontMatter {
+		fm = mc.documentFrontMatter(doc)
+	}
+	if mc.HeadersFooters == HeadersFootersFrontMatter && len(tabs) > 0 {
+		fm.Header, fm.Footer = mc.headerFooterText(TabDocument(doc, tabs[0]))
+	}
+	fmMd, err := fm.Markdown()
+	if err != nil {
+		return nil, err
+	}
+	md = append(md, fmMd...)
+
+	frontMatter, headersFooters := mc.FrontMatter, mc.HeadersFooters
+	defer func() {
+		mc.FrontMatter, mc.HeadersFooters = frontMatter, headersFooters
+		mc.tabSections, mc.footnoteBase = false, 0
+	}()
+	mc.FrontMatter = false
+	if mc.HeadersFooters == HeadersFootersFrontMatter {
+		mc.HeadersFooters = HeadersFootersOmitted
+	}
+	mc.tabSections, mc.footnoteBase = true, 0
+	if err := mc.prepareTabSections(doc, tabs); err != nil {
+		return nil, err
+	}
+	for _, tab := range tabs {
+		section, err := mc.TabAsMarkdown(doc, tab)
+		if err != nil {
+			return nil, err
+		}
+		md = append(md, string(section))
+		mc.footnoteBase += len(mc.footnotes)
+	}
+	if comments := mc.commentsAsMarkdown(); len(comments) > 0 {
+		md = append(md, strings.Join(comments, "\n"))
+	}
+	return []byte(strings.Join(md, "\n")), nil
+}
+
+// prepareTabSections does the work AsMarkdown does for each document once
+// for all the tabs exported as sections: it anchors comments, downloads
+// images and generates heading anchors across the tabs.
+func (mc *MarkdownConverter) prepareTabSections(doc *docs.Document, tabs []*docs.Tab) error {
+	if err := mc.anchorComments(doc); err != nil {
+		return err
+	}
+	chips, err := mc.ChipTemplates.parse()
+	if err != nil {
+		return err
+	}
+	mc.chips = chips
+	mc.images = map[string]string{}
+	mc.anchors = map[headingKey]string{}
+	ids := parser.NewContext().IDs()
+	defer func() { mc.tab, mc.tabID, mc.footnoteBase = nil, "", 0 }()
+	for _, tab := range tabs {
+		tabDoc := TabDocument(doc, tab)
+		if err := mc.downloadImages(tabDoc); err != nil {
+			return err
+		}
+		mc.tab = tab.TabProperties
+		if mc.tab == nil {
+			mc.tab = &docs.TabProperties{}
+		}
+		mc.tabID = mc.tab.TabId
+		mc.doc, mc.footnotes = tabDoc, nil
+		title, titleLevel := mc.title(tabDoc)
+		mc.addHeadingAnchors(ids, tabDoc, title, titleLevel > 0)
+		if tabDoc.Body != nil {
+			mc.footnoteBase += len(footnoteReferences(tabDoc.Body.Content))
+		}
+	}
+	return nil
+}
+
+// setTabID points the locations and ranges of requests at a tab. Headers and
+// footers are created for the tab's first section.
+func setTabID(requests []*docs.Request, tabID string) {
+	for _, r := range requests {
+		var locations []*docs.Location
+		var ranges []*docs.Range
+		switch {
+		case r.InsertText != nil:
+			locations = append(locations, r.InsertText.Location)
+			if l := r.InsertText.EndOfSegmentLocation; l != nil {
+				l.TabId = tabID
+			}
+		case r.InsertTable != nil:
+			locations = append(locations, r.InsertTable.Location)
+		case r.InsertInlineImage != nil:
+			locations = append(locations, r.InsertInlineImage.Location)
+		case r.InsertPageBreak != nil:
+			locations = append(locations, r.InsertPageBreak.Location)
+		case r.InsertSectionBreak != nil:
+			locations = append(locations, r.InsertSectionBreak.Location)
+		case r.CreateFootnote != nil:
+			locations = append(locations, r.CreateFootnote.Location)
+		case r.CreateHeader != nil:
+			if r.CreateHeader.SectionBreakLocation == nil {
+				r.CreateHeader.SectionBreakLocation = &docs.Location{}
+			}
+			locations = append(locations, r.CreateHeader.SectionBreakLocation)
+		case r.CreateFooter != nil:
+			if r.CreateFooter.SectionBreakLocation == nil {
+				r.CreateFooter.SectionBreakLocation = &docs.Location{}
+			}
+			locations = append(locations, r.CreateFooter.SectionBreakLocation)
+		case r.UpdateParagraphStyle != nil:
+			ranges = append(ranges, r.UpdateParagraphStyle.Range)
+		case r.UpdateTextStyle != nil:
+			ranges = append(ranges, r.UpdateTextStyle.Range)
+		case r.CreateParagraphBullets != nil:
+			ranges = append(ranges, r.CreateParagraphBullets.Range)
+		case r.DeleteContentRange != nil:
+			ranges = append(ranges, r.DeleteContentRange.Range)
+		}
+		for _, l := range locations {
+			if l != nil {
+				l.TabId = tabID
+			}
+		}
+		for _, rng := range ranges {
+			if rng != nil {
+				rng.TabId = tabID
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yuin/goldmark/text"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+)
+
+func tab(id, title string, nesting int64, body string, children ...*docs.Tab) *docs.Tab {
+	return &docs.Tab{
+		TabProperties: &docs.TabProperties{TabId: id, Title: title, NestingLevel: nesting},
+		DocumentTab: &docs.DocumentTab{Body: &docs.Body{Content: []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun(body+"\n")),
+		}}},
+		ChildTabs: children,
+	}
+}
+
+// withTabsContent returns doc in the shape it is retrieved in with its tabs'
+// content, with its body in a single tab.
+func withTabsContent(doc *docs.Document) *docs.Document {
+	d := *doc
+	d.Body = nil
+	d.Tabs = []*docs.Tab{{
+		TabProperties: &docs.TabProperties{TabId: "t.0", Title: "Tab 1"},
+		DocumentTab:   &docs.DocumentTab{Body: doc.Body},
+	}}
+	return &d
+}
+
+func TestAsMarkdownTabs(t *testing.T) {
+	doc := &docs.Document{
+		DocumentId: "abc",
+		Title:      "Plan",
+		Tabs: []*docs.Tab{
+			tab("t.0", "Goals", 0, "Ship it", tab("t.1", "Stretch", 1, "Ship more")),
+			tab("t.2", "Notes", 0, "None"),
+		},
+	}
+	got, err := NewMarkdownConverter().AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	want := "# Goals\nShip it\n\n\n## Stretch\nShip more\n\n\n# Notes\nNone\n\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+	}
+
+	single := &docs.Document{Title: "Plan", Tabs: []*docs.Tab{tab("t.0", "Tab 1", 0, "Only")}}
+	got, err = NewMarkdownConverter().AsMarkdown(single)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	if diff := cmp.Diff("# Plan\nOnly\n\n", string(got)); diff != "" {
+		t.Errorf("AsMarkdown of single tab mismatch (-want +got):\n%s", diff)
+	}
+
+	mc := NewMarkdownConverter()
+	mc.FrontMatter = true
+	mc.now = func() time.Time { return time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC) }
+	got, err = mc.TabAsMarkdown(doc, FindTab(doc, "Stretch"))
+	if err != nil {
+		t.Fatalf("TabAsMarkdown: %v", err)
+	}
+	want = "---\n" +
+		"document_id: abc\n" +
+		"tab_id: t.1\n" +
+		"title: Plan\n" +
+		"last_synced: 2024-03-01T00:00:00Z\n" +
+		"source_url: https://docs.google.com/document/d/abc/edit?tab=t.1\n" +
+		"---\n" +
+		"## Stretch\nShip more\n\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("TabAsMarkdown mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAsMarkdownTabsCommentsFootnotes(t *testing.T) {
+	footnoted := func(id, title, text, footnote string) *docs.Tab {
+		tb := tab(id, title, 0, "")
+		ref := &docs.FootnoteReference{FootnoteId: "kix." + id, FootnoteNumber: "1"}
+		tb.DocumentTab.Body.Content = []*docs.StructuralElement{
+			paragraph("NORMAL_TEXT", textRun(text), &docs.ParagraphElement{FootnoteReference: ref}, textRun("\n")),
+		}
+		tb.DocumentTab.Footnotes = map[string]docs.Footnote{
+			"kix." + id: {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun(footnote+"\n"))}},
+		}
+		return tb
+	}
+	doc := &docs.Document{
+		DocumentId: "abc",
+		Title:      "Plan",
+		Tabs: []*docs.Tab{
+			footnoted("t.0", "Goals", "Ship it", "Soon."),
+			footnoted("t.1", "Notes", "Test it", "Later."),
+		},
+	}
+	comments := &fakeCommentService{comments: []*drive.Comment{
+		comment("Ada", "When?", "Ship it"),
+		comment("Bo", "How?", "Test it"),
+		comment("Cy", "Gone", "deleted text"),
+	}}
+	mc := NewMarkdownConverter()
+	mc.Comments = comments
+	mc.CommentStyle = CommentsFootnotes
+	got, err := mc.AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	if comments.calls != 1 {
+		t.Errorf("comments listed %d times, want once", comments.calls)
+	}
+	want := "# Goals\nShip it[^comment-1][^1]\n\n\n\n[^1]: Soon.\n" +
+		"# Notes\nTest it[^comment-2][^2]\n\n\n\n[^2]: Later.\n" +
+		"\n[^comment-1]: Ada: When?\n\n[^comment-2]: Bo: How?\n\n<!-- Cy: Gone -->"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("AsMarkdown mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAsMarkdownTabsHeadingLinks(t *testing.T) {
+	intro := func(tabID, headingID string, elems ...*docs.ParagraphElement) *docs.StructuralElement {
+		h := paragraph("HEADING_1", append([]*docs.ParagraphElement{textRun("Intro")}, append(elems, textRun("\n"))...)...)
+		h.Paragraph.ParagraphStyle.HeadingId = headingID
+		return h
+	}
+	linkTo := func(text, tabID, headingID string) *docs.ParagraphElement {
+		run := textRun(text)
+		run.TextRun.TextStyle.Link = &docs.Link{Heading: &docs.HeadingLink{Id: headingID, TabId: tabID}, TabId: tabID}
+		return run
+	}
+	footnote := func(id string) *docs.ParagraphElement {
+		return &docs.ParagraphElement{FootnoteReference: &docs.FootnoteReference{FootnoteId: id, FootnoteNumber: "1"}}
+	}
+	goals := tab("t.0", "Goals", 0, "")
+	goals.DocumentTab.Body.Content = []*docs.StructuralElement{
+		paragraph("NORMAL_TEXT", textRun("Claim"), footnote("kix.a"), textRun("\n")),
+		intro("t.0", "h.a"),
+		paragraph("NORMAL_TEXT", linkTo("next", "t.1", "h.b"), textRun("\n")),
+	}
+	goals.DocumentTab.Footnotes = map[string]docs.Footnote{
+		"kix.a": {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun("A.\n"))}},
+	}
+	notes := tab("t.1", "Notes", 0, "")
+	notes.DocumentTab.Body.Content = []*docs.StructuralElement{
+		intro("t.1", "h.b", footnote("kix.b")),
+		paragraph("NORMAL_TEXT", linkTo("back", "t.0", "h.a"), textRun("\n")),
+	}
+	notes.DocumentTab.Footnotes = map[string]docs.Footnote{
+		"kix.b": {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun("B.\n"))}},
+	}
+	doc := &docs.Document{DocumentId: "abc", Title: "Plan", Tabs: []*docs.Tab{goals, notes}}
+	got, err := NewMarkdownConverter().AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	// The links must point at the IDs goldmark gives the headings of the
+	// whole file.
+	root := NewMarkdownParser().Parse(text.NewReader(got))
+	var anchors []string
+	for _, h := range markdownHeadings(root, got) {
+		anchors = append(anchors, h.anchor)
+	}
+	if diff := cmp.Diff([]string{"goals", "intro", "notes", "intro2"}, anchors); diff != "" {
+		t.Errorf("heading anchors mismatch (-want +got):\n%s", diff)
+	}
+	for _, want := range []string{"[next](#intro2)", "[back](#intro)"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("AsMarkdown = %q, want link %q", got, want)
+		}
+	}
+}
+
+func TestFindTab(t *testing.T) {
+	doc := &docs.Document{Tabs: []*docs.Tab{
+		tab("t.0", "t.1", 0, ""),
+		tab("t.1", "Notes", 0, ""),
+	}}
+	for _, tt := range []struct {
+		idOrTitle string
+		want      string
+	}{
+		{"t.0", "t.0"},
+		// IDs take precedence over titles.
+		{"t.1", "t.1"},
+		{"Notes", "t.1"},
+		{"missing", ""},
+	} {
+		var got string
+		if tab := FindTab(doc, tt.idOrTitle); tab != nil {
+			got = tab.TabProperties.TabId
+		}
+		if got != tt.want {
+			t.Errorf("FindTab(%q) = %q, want %q", tt.idOrTitle, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownToDocTab(t *testing.T) {
+	dc := NewDocConverter()
+	dc.TabID = "t.1"
+	requests := markdownRequests(t, dc, "# Title\n\nSome *text*\n\n- item\n")
+	for _, r := range requests {
+		var tabIDs []string
+		switch {
+		case r.InsertText != nil:
+			tabIDs = append(tabIDs, r.InsertText.Location.TabId)
+		case r.UpdateParagraphStyle != nil:
+			tabIDs = append(tabIDs, r.UpdateParagraphStyle.Range.TabId)
+		case r.UpdateTextStyle != nil:
+			tabIDs = append(tabIDs, r.UpdateTextStyle.Range.TabId)
+		case r.CreateParagraphBullets != nil:
+			tabIDs = append(tabIDs, r.CreateParagraphBullets.Range.TabId)
+		default:
+			t.Errorf("unexpected request %s", jmar(r))
+		}
+		for _, id := range tabIDs {
+			if id != "t.1" {
+				t.Errorf("request %s is not in tab t.1", jmar(r))
+			}
+		}
+	}
+}
+
+func TestMarkdownToDocTabHeader(t *testing.T) {
+	dc := NewDocConverter()
+	dc.TabID = "t.1"
+	requests := documentRequests(t, dc, &docs.Document{DocumentId: "doc"}, "---\nheader: Draft\n---\nBody\n")
+	var created, written bool
+	for _, r := range requests {
+		switch {
+		case r.CreateHeader != nil:
+			created = true
+			if l := r.CreateHeader.SectionBreakLocation; l == nil || l.TabId != "t.1" {
+				t.Errorf("header created outside tab t.1: %s", jmar(r))
+			}
+		case r.InsertText != nil && r.InsertText.EndOfSegmentLocation != nil:
+			written = true
+			if r.InsertText.EndOfSegmentLocation.TabId != "t.1" {
+				t.Errorf("header text written outside tab t.1: %s", jmar(r))
+			}
+		}
+	}
+	if !created || !written {
+		t.Errorf("header created = %v, written = %v; want both", created, written)
+	}
+}
@@ -90,6 +90,50 @@ func (tds *TestingDocsService) DoBatchUpdate(documentId string, req *docs.BatchU
 	return resp, nil
 }
 
+func (tds *TestingDocsService) GetDocument(documentId string) (*docs.Document, error) {
+	tds.t.Helper()
+	// Ensure the testdata directory exists
+	if _, err := os.Stat("testdata"); os.IsNotExist(err) {
+		os.Mkdir("testdata", 0755)
+	}
+	// Filename for the golden document based on the test name
+	filename := fmt.Sprintf("testdata/golden_document_%s.json", strings.ReplaceAll(tds.t.Name(), "/", "_"))
+
+	// If not updating golden, attempt to use the saved document
+	if !*updateGolden {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			tds.t.Fatalf("Failed to read golden document (run with -updateGolden to record it): %v", err)
+		}
+
+		var doc docs.Document
+		err = json.Unmarshal(data, &doc)
+		if err != nil {
+			tds.t.Fatalf("Failed to unmarshal golden document: %v", err)
+		}
+
+		return &doc, nil
+	}
+
+	// Otherwise, make the actual API call
+	doc, err := tds.realService.Documents.Get(documentId).IncludeTabsContent(true).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	// If updating golden files, save the new document
+	data, err := doc.MarshalJSON()
+	if err != nil {
+		tds.t.Fatalf("Failed to marshal document: %v", err)
+	}
+	err = ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		tds.t.Fatalf("Failed to save golden document: %v", err)
+	}
+
+	return doc, nil
+}
+
 func NewRealDocsService(t *testing.T) *docs.Service {
 	t.Helper()
 	srv, err := newRealDocsService()
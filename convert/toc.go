@@ -0,0 +1,76 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"google.golang.org/api/docs/v1"
+)
+
+// DefaultTOCMarker is the Markdown block a table of contents is generated
+// in place of.
+const DefaultTOCMarker = "[TOC]"
+
+// tableOfContentsAsMarkdown returns a table of contents as a nested list of
+// links to the anchors of the headings it lists.
+func (mc *MarkdownConverter) tableOfContentsAsMarkdown(toc *docs.TableOfContents) []string {
+	type entry struct {
+		text, anchor string
+		level        int
+	}
+	styles := headingStyles(mc.doc)
+	var entries []entry
+	minLevel := 0
+	for _, s := range toc.Content {
+		if s.Paragraph == nil {
+			continue
+		}
+		text := strings.TrimSpace(paragraphText(s.Paragraph))
+		if text == "" {
+			continue
+		}
+		e := entry{text: text, level: 1}
+		for _, elem := range s.Paragraph.Elements {
+			if elem.TextRun == nil || elem.TextRun.TextStyle == nil || elem.TextRun.TextStyle.Link == nil {
+				continue
+			}
+			heading := mc.linkedHeading(elem.TextRun.TextStyle.Link)
+			e.anchor = mc.anchors[heading]
+			if style, ok := styles[heading.headingID]; ok {
+				e.level = headingLevel(style.NamedStyleType)
+			}
+			break
+		}
+		if minLevel == 0 || e.level < minLevel {
+			minLevel = e.level
+		}
+		entries = append(entries, e)
+	}
+	var md []string
+	for _, e := range entries {
+		item := escapeLineStart(escapeMarkdown(e.text))
+		if e.anchor != "" {
+			item = "[" + escapeMarkdown(e.text) + "](#" + e.anchor + ")"
+		}
+		md = append(md, strings.Repeat(" ", (e.level-minLevel)*mc.ListIndent)+"- "+item)
+	}
+	if len(md) == 0 {
+		return nil
+	}
+	return append(md, "\n")
+}
+
+// isTOCMarker reports whether a top-level Markdown block consists only of the
+// table of contents marker.
+func (dc *DocConverter) isTOCMarker(n ast.Node, source []byte) bool {
+	if dc.TOCMarker == "" {
+		return false
+	}
+	if _, ok := n.Parent().(*ast.Document); !ok {
+		return false
+	}
+	if _, ok := n.(*ast.Paragraph); !ok {
+		return false
+	}
+	return strings.TrimSpace(string(blockLines(n, source))) == dc.TOCMarker
+}
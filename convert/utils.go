@@ -1,32 +1,369 @@
 package convert
 
 import (
+	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
 	"google.golang.org/api/docs/v1"
 )
 
-func processTextRuns(elements ...*docs.TextRun) string {
-	var md []string
-	for _, tr := range elements {
-		text := tr.Content
-		if tr.TextStyle.Bold {
-			text = "**" + text + "**"
+// inlineDelimiters are the Markdown written before and after text with one
+// kind of inline formatting.
+type inlineDelimiters struct {
+	open, close string
+}
+
+// processTextRuns returns the Markdown for consecutive text runs. Formatting
+// shared by adjacent runs is written once around all of them, and whitespace
+// at the edges of formatted text is written outside its delimiters, where
+// CommonMark requires it to be. Emphasis that CommonMark would not recognise
+// where it falls, such as bold punctuation inside a word, is written as HTML.
+func (mc *MarkdownConverter) processTextRuns(elements ...*docs.TextRun) string {
+	var (
+		pieces  []markdownPiece
+		open    []int  // indexes of the opening pieces of the formatting in effect
+		pending string // whitespace after the last text, written once the formatting around it is closed
+	)
+	closeTo := func(n int) {
+		for i := len(open) - 1; i >= n; i-- {
+			d := pieces[open[i]].delims
+			pieces[open[i]].pair = len(pieces)
+			pieces = append(pieces, markdownPiece{text: d.close, delims: d, pair: open[i]})
+		}
+		open = open[:n]
+	}
+	for _, run := range mc.mergeTextRuns(elements) {
+		leading, text, trailing := splitSpace(run.Content)
+		if text == "" {
+			pending += run.Content
+			continue
+		}
+		want := mc.runDelimiters(run)
+		keep := 0
+		for keep < len(open) && containsDelimiters(want, pieces[open[keep]].delims) {
+			keep++
+		}
+		closeTo(keep)
+		pieces = append(pieces, markdownPiece{text: pending + leading, pair: -1})
+		for _, d := range want {
+			if !delimitersOpen(pieces, open, d) {
+				open = append(open, len(pieces))
+				pieces = append(pieces, markdownPiece{text: d.open, delims: d})
+			}
+		}
+		if mc.isMonospace(run.TextStyle) {
+			// Code spans cannot hold line breaks, so each line is a span.
+			var spans []string
+			for _, line := range strings.Split(text, "\v") {
+				if line = strings.TrimSpace(line); line != "" {
+					spans = append(spans, codeSpan(line))
+				}
+			}
+			text = strings.Join(spans, "\v")
+		} else {
+			text = escapeMarkdown(text)
+		}
+		pieces = append(pieces, markdownPiece{text: text, pair: -1})
+		pending = trailing
+	}
+	closeTo(0)
+	pieces = append(pieces, markdownPiece{text: pending, pair: -1})
+
+	for changed := true; changed; {
+		changed = false
+		for i, p := range pieces {
+			tags, ok := emphasisTags[p.delims]
+			if !ok || p.pair < i || p.text != p.delims.open {
+				continue
+			}
+			if canOpenDelimiter(pieces, i) && canCloseDelimiter(pieces, p.pair) && !followsClose(pieces, i) {
+				continue
+			}
+			pieces[i].text, pieces[p.pair].text = tags.open, tags.close
+			changed = true
+		}
+	}
+	var b strings.Builder
+	for _, p := range pieces {
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
+
+// markdownPiece is text or a delimiter written by processTextRuns.
+type markdownPiece struct {
+	text   string
+	delims inlineDelimiters // the delimiters the piece opens or closes, if any
+	pair   int              // the index of the matching delimiter piece, or -1 for text
+}
+
+// emphasisTags are the HTML written instead of emphasis delimiters that
+// CommonMark would not recognise.
+var emphasisTags = map[inlineDelimiters]inlineDelimiters{
+	{"~~", "~~"}: {"<del>", "</del>"},
+	{"**", "**"}: {"<strong>", "</strong>"},
+	{"*", "*"}:   {"<em>", "</em>"},
+}
+
+// delimitersOpen reports whether d is among the opening pieces at open.
+func delimitersOpen(pieces []markdownPiece, open []int, d inlineDelimiters) bool {
+	for _, i := range open {
+		if pieces[i].delims == d {
+			return true
+		}
+	}
+	return false
+}
+
+// canOpenDelimiter reports whether the delimiter piece at i is left-flanking,
+// so CommonMark can open emphasis with it. Text before the first piece is
+// taken to be whitespace or punctuation, which is what paragraphs start with
+// and other paragraph elements end with.
+func canOpenDelimiter(pieces []markdownPiece, i int) bool {
+	before, after := delimiterNeighbours(pieces, i)
+	return !util.IsSpaceRune(after) &&
+		(!util.IsPunctRune(after) || util.IsSpaceRune(before) || util.IsPunctRune(before))
+}
+
+// canCloseDelimiter reports whether the delimiter piece at i is
+// right-flanking, so CommonMark can close emphasis with it.
+func canCloseDelimiter(pieces []markdownPiece, i int) bool {
+	before, after := delimiterNeighbours(pieces, i)
+	return !util.IsSpaceRune(before) &&
+		(!util.IsPunctRune(before) || util.IsSpaceRune(after) || util.IsPunctRune(after))
+}
+
+// followsClose reports whether the delimiter piece at i directly follows
+// closing delimiters of the same character, such as when bold text ends
+// where italic text starts. CommonMark would read them as one run, which
+// neither closes nor opens as intended.
+func followsClose(pieces []markdownPiece, i int) bool {
+	c := pieces[i].text[0]
+	for j := i - 1; j >= 0; j-- {
+		if p := pieces[j]; p.text != "" {
+			return p.pair >= 0 && p.pair < j && strings.Trim(p.text, string(c)) == ""
+		}
+	}
+	return false
+}
+
+// delimiterNeighbours returns the characters before and after the run of
+// delimiter characters holding the piece at i, as CommonMark sees them.
+func delimiterNeighbours(pieces []markdownPiece, i int) (before, after rune) {
+	c := pieces[i].text[0]
+	sameRun := func(p markdownPiece) bool {
+		return p.pair >= 0 && strings.Trim(p.text, string(c)) == ""
+	}
+	before, after = ' ', ' '
+	for j := i - 1; j >= 0; j-- {
+		if p := pieces[j]; p.text != "" && !sameRun(p) {
+			before, _ = utf8.DecodeLastRuneInString(p.text)
+			break
+		}
+	}
+	for j := i + 1; j < len(pieces); j++ {
+		if p := pieces[j]; p.text != "" && !sameRun(p) {
+			after, _ = utf8.DecodeRuneInString(p.text)
+			break
+		}
+	}
+	return before, after
+}
+
+// runDelimiters returns the delimiters for a text run's formatting, from the
+// outermost to the innermost.
+func (mc *MarkdownConverter) runDelimiters(tr *docs.TextRun) []inlineDelimiters {
+	var ds []inlineDelimiters
+	if mc.CriticMarkup {
+		switch {
+		case len(tr.SuggestedInsertionIds) > 0:
+			ds = append(ds, inlineDelimiters{"{++", "++}"})
+		case len(tr.SuggestedDeletionIds) > 0:
+			ds = append(ds, inlineDelimiters{"{--", "--}"})
+		}
+	}
+	style := tr.TextStyle
+	if style == nil {
+		return ds
+	}
+	if style.Link != nil {
+		ds = append(ds, inlineDelimiters{"[", "](" + markdownDestination(mc.linkDestination(style.Link)) + ")"})
+	}
+	if style.Strikethrough {
+		ds = append(ds, inlineDelimiters{"~~", "~~"})
+	}
+	if style.Bold {
+		ds = append(ds, inlineDelimiters{"**", "**"})
+	}
+	if style.Italic {
+		ds = append(ds, inlineDelimiters{"*", "*"})
+	}
+	if style.Underline && style.Link == nil {
+		// Markdown doesn't support underline, but it can be represented using HTML
+		ds = append(ds, inlineDelimiters{"<u>", "</u>"})
+	}
+	return ds
+}
+
+// mergeTextRuns joins adjacent text runs written with the same Markdown, so
+// that, for example, neighbouring code spans become one.
+func (mc *MarkdownConverter) mergeTextRuns(runs []*docs.TextRun) []*docs.TextRun {
+	var merged []*docs.TextRun
+	for _, tr := range runs {
+		if n := len(merged); n > 0 && mc.sameMarkdownStyle(merged[n-1], tr) {
+			last := *merged[n-1]
+			last.Content += tr.Content
+			merged[n-1] = &last
+			continue
 		}
-		if tr.TextStyle.Italic {
-			text = "*" + text + "*"
+		merged = append(merged, tr)
+	}
+	return merged
+}
+
+// sameMarkdownStyle reports whether two text runs are written with the same
+// Markdown formatting.
+func (mc *MarkdownConverter) sameMarkdownStyle(a, b *docs.TextRun) bool {
+	if mc.isMonospace(a.TextStyle) != mc.isMonospace(b.TextStyle) {
+		return false
+	}
+	da, db := mc.runDelimiters(a), mc.runDelimiters(b)
+	if len(da) != len(db) {
+		return false
+	}
+	for i := range da {
+		if da[i] != db[i] {
+			return false
 		}
-		if tr.TextStyle.Strikethrough {
-			text = "~~" + text + "~~"
+	}
+	return true
+}
+
+func containsDelimiters(ds []inlineDelimiters, d inlineDelimiters) bool {
+	for _, x := range ds {
+		if x == d {
+			return true
 		}
-		if tr.TextStyle.Underline && tr.TextStyle.Link == nil {
-			// Markdown doesn't support underline, but it can be represented using HTML
-			text = "<u>" + text + "</u>"
+	}
+	return false
+}
+
+// splitSpace splits s into its leading whitespace, the text between, and its
+// trailing whitespace.
+func splitSpace(s string) (leading, text, trailing string) {
+	text = strings.TrimLeftFunc(s, unicode.IsSpace)
+	leading = s[:len(s)-len(text)]
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	trailing = s[len(leading)+len(text):]
+	return leading, text, trailing
+}
+
+var (
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		"`", "\\`",
+		"*", `\*`,
+		"_", `\_`,
+		"[", `\[`,
+		"]", `\]`,
+		"<", `\<`,
+		"~", `\~`,
+	)
+	// entityPattern matches the HTML entities Markdown parsers decode.
+	entityPattern = regexp.MustCompile(`&(#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);`)
+	// entityPrefixPattern matches an HTML entity at the start of text.
+	entityPrefixPattern = regexp.MustCompile(`^` + entityPattern.String())
+	// orderedListPattern matches text that would start an ordered list item.
+	orderedListPattern = regexp.MustCompile(`^[0-9]{1,9}[.)]`)
+)
+
+// escapeMarkdown escapes the characters of text that Markdown would
+// otherwise read as inline formatting.
+func escapeMarkdown(text string) string {
+	text = markdownEscaper.Replace(text)
+	return entityPattern.ReplaceAllString(text, `\$0`)
+}
+
+// markdownText returns the text a Markdown text node stands for. Outside code
+// spans, backslash escapes are removed and entity and character references
+// are resolved, as Markdown renderers do.
+func markdownText(n *ast.Text, source []byte) string {
+	value := n.Segment.Value(source)
+	if _, ok := n.Parent().(*ast.CodeSpan); ok {
+		return string(value)
+	}
+	var b []byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && i+1 < len(value) && util.IsPunct(value[i+1]):
+			i++
+			b = append(b, value[i])
+		case c == '&' && entityPrefixPattern.Match(value[i:]):
+			ref := entityPrefixPattern.Find(value[i:])
+			b = append(b, util.ResolveEntityNames(util.ResolveNumericReferences(ref))...)
+			i += len(ref) - 1
+		default:
+			b = append(b, c)
 		}
-		if tr.TextStyle.Link != nil {
-			text = "[" + text + "](" + tr.TextStyle.Link.Url + ")"
+	}
+	return string(b)
+}
+
+// escapeLineStart escapes the start of a line of Markdown that would
+// otherwise be read as the start of a heading, block quote, list item or
+// thematic break. Inline formatting characters are escaped by
+// escapeMarkdown, so formatting the line starts with is left alone.
+func escapeLineStart(line string) string {
+	switch {
+	case line == "":
+		return line
+	case strings.ContainsRune("#>+-=", rune(line[0])):
+		return `\` + line
+	case orderedListPattern.MatchString(line):
+		i := strings.IndexAny(line, ".)")
+		return line[:i] + `\` + line[i:]
+	}
+	return line
+}
+
+// markdownDestination returns a link destination as written in Markdown,
+// wrapping destinations that would otherwise end the link early in angle
+// brackets.
+func markdownDestination(dest string) string {
+	if strings.ContainsAny(dest, " ()") {
+		return "<" + dest + ">"
+	}
+	return dest
+}
+
+// codeSpan wraps code in a backtick string longer than any run of backticks it
+// contains, padding it with spaces where the delimiters would otherwise be
+// ambiguous.
+func codeSpan(code string) string {
+	fence := strings.Repeat("`", longestRun(code, '`')+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") ||
+		strings.HasPrefix(code, " ") && strings.HasSuffix(code, " ") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+// longestRun returns the length of the longest run of c in s.
+func longestRun(s string, c rune) int {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == c {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
 		}
-		md = append(md, strings.TrimSpace(text))
 	}
-	return strings.Join(md, "")
+	return longest
 }
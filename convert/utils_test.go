@@ -0,0 +1,300 @@
+package convert
+
+import (
+	"html"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/docs/v1"
+)
+
+func TestProcessTextRunsCodeSpans(t *testing.T) {
+	mono := func(content string, style docs.TextStyle) *docs.TextRun {
+		style.WeightedFontFamily = &docs.WeightedFontFamily{FontFamily: "Roboto Mono"}
+		return &docs.TextRun{Content: content, TextStyle: &style}
+	}
+	tests := []struct {
+		name string
+		runs []*docs.TextRun
+		want string
+	}{
+		{
+			name: "plain",
+			runs: []*docs.TextRun{mono("--verbose", docs.TextStyle{})},
+			want: "`--verbose`",
+		},
+		{
+			name: "backticks inside",
+			runs: []*docs.TextRun{mono("a``b", docs.TextStyle{})},
+			want: "```a``b```",
+		},
+		{
+			name: "leading backtick",
+			runs: []*docs.TextRun{mono("`x", docs.TextStyle{})},
+			want: "`` `x ``",
+		},
+		{
+			name: "bold link",
+			runs: []*docs.TextRun{mono("Get", docs.TextStyle{
+				Bold: true,
+				Link: &docs.Link{Url: "https://example.com"},
+			})},
+			want: "[**`Get`**](https://example.com)",
+		},
+		{
+			name: "other fonts",
+			runs: []*docs.TextRun{{
+				Content: "Arial",
+				TextStyle: &docs.TextStyle{
+					WeightedFontFamily: &docs.WeightedFontFamily{FontFamily: "Arial"},
+				},
+			}},
+			want: "Arial",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewMarkdownConverter().processTextRuns(tt.runs...); got != tt.want {
+				t.Errorf("processTextRuns() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessTextRunsCriticMarkup(t *testing.T) {
+	runs := []*docs.TextRun{
+		{Content: "kept ", TextStyle: &docs.TextStyle{}},
+		{Content: "added", TextStyle: &docs.TextStyle{Bold: true}, SuggestedInsertionIds: []string{"suggest.1"}},
+		{Content: "removed", TextStyle: &docs.TextStyle{}, SuggestedDeletionIds: []string{"suggest.2"}},
+		{Content: "\n", TextStyle: &docs.TextStyle{}, SuggestedInsertionIds: []string{"suggest.1"}},
+	}
+	mc := NewMarkdownConverter()
+	if got, want := mc.processTextRuns(runs...), "kept **added**removed\n"; got != want {
+		t.Errorf("processTextRuns = %q, want %q", got, want)
+	}
+	mc.CriticMarkup = true
+	if got, want := mc.processTextRuns(runs...), "kept {++**added**++}{--removed--}\n"; got != want {
+		t.Errorf("processTextRuns with CriticMarkup = %q, want %q", got, want)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	texts := []string{
+		"2 * 3 * 4",
+		"snake_case_name and _under_",
+		"[not a link](x) ![nor an image](y)",
+		"# not a heading",
+		"- not a list",
+		"+ not a list",
+		"12. not a list",
+		"3) not a list",
+		"> not a quote",
+		"=",
+		"---",
+		"<b>not html</b>",
+		"a ~~not struck~~ b",
+		"&amp; &#35; & stays",
+		`back\slash\`,
+		"`not code`",
+	}
+	for _, text := range texts {
+		t.Run(text, func(t *testing.T) {
+			mc := NewMarkdownConverter()
+			md := mc.paragraphAsMarkdown(paragraph("NORMAL_TEXT", textRun(text+"\n")).Paragraph)
+			if got, want := markdownToHTML(md[0]), html.EscapeString(text); got != want {
+				t.Errorf("Markdown %q renders as %q, want %q", md[0], got, want)
+			}
+		})
+	}
+}
+
+func TestEscapeMarkdownLinkText(t *testing.T) {
+	run := &docs.TextRun{
+		Content:   "see [1] *now*",
+		TextStyle: &docs.TextStyle{Link: &docs.Link{Url: "https://example.com/a (b)"}},
+	}
+	md := NewMarkdownConverter().processTextRuns(run)
+	want := `<a href="https://example.com/a%20(b)">see [1] *now*</a>`
+	if got := markdownToHTML(md); got != want {
+		t.Errorf("Markdown %q renders as %q, want %q", md, got, want)
+	}
+}
+
+func TestProcessTextRunsEmphasis(t *testing.T) {
+	bold := &docs.TextStyle{Bold: true}
+	italic := &docs.TextStyle{Italic: true}
+	boldItalic := &docs.TextStyle{Bold: true, Italic: true}
+	link := &docs.Link{Url: "https://example.com"}
+	code := &docs.TextStyle{WeightedFontFamily: &docs.WeightedFontFamily{FontFamily: "Courier New"}}
+	tests := []struct {
+		name     string
+		runs     []*docs.TextRun
+		wantMd   string
+		wantHTML string
+	}{
+		{
+			name: "whitespace outside delimiters",
+			runs: []*docs.TextRun{
+				{Content: "a", TextStyle: &docs.TextStyle{}},
+				{Content: " bold ", TextStyle: bold},
+				{Content: "b\n", TextStyle: &docs.TextStyle{}},
+			},
+			wantMd:   "a **bold** b\n",
+			wantHTML: "a <strong>bold</strong> b",
+		},
+		{
+			name: "identical runs merged",
+			runs: []*docs.TextRun{
+				{Content: "one ", TextStyle: bold},
+				{Content: "two", TextStyle: bold},
+			},
+			wantMd:   "**one two**",
+			wantHTML: "<strong>one two</strong>",
+		},
+		{
+			name: "bold and italic",
+			runs: []*docs.TextRun{
+				{Content: "both", TextStyle: boldItalic},
+			},
+			wantMd:   "***both***",
+			wantHTML: "<em><strong>both</strong></em>",
+		},
+		{
+			name: "italic inside bold",
+			runs: []*docs.TextRun{
+				{Content: "bold ", TextStyle: bold},
+				{Content: "both", TextStyle: boldItalic},
+				{Content: " bold", TextStyle: bold},
+			},
+			wantMd:   "**bold *both* bold**",
+			wantHTML: "<strong>bold <em>both</em> bold</strong>",
+		},
+		{
+			name: "bold ending inside italic",
+			runs: []*docs.TextRun{
+				{Content: "both ", TextStyle: boldItalic},
+				{Content: "italic", TextStyle: italic},
+			},
+			wantMd:   "***both*** *italic*",
+			wantHTML: "<em><strong>both</strong></em> <em>italic</em>",
+		},
+		{
+			name: "link across styles",
+			runs: []*docs.TextRun{
+				{Content: "bold", TextStyle: &docs.TextStyle{Bold: true, Link: link}},
+				{Content: " plain", TextStyle: &docs.TextStyle{Link: link}},
+			},
+			wantMd:   "[**bold** plain](https://example.com)",
+			wantHTML: `<a href="https://example.com"><strong>bold</strong> plain</a>`,
+		},
+		{
+			name: "adjacent code runs",
+			runs: []*docs.TextRun{
+				{Content: "go ", TextStyle: code},
+				{Content: "test", TextStyle: code},
+			},
+			wantMd:   "`go test`",
+			wantHTML: "<code>go test</code>",
+		},
+		{
+			name: "bold punctuation inside word",
+			runs: []*docs.TextRun{
+				{Content: "foo", TextStyle: &docs.TextStyle{}},
+				{Content: "(bar)", TextStyle: bold},
+				{Content: "baz", TextStyle: &docs.TextStyle{}},
+			},
+			wantMd:   "foo<strong>(bar)</strong>baz",
+			wantHTML: "foo<strong>(bar)</strong>baz",
+		},
+		{
+			name: "italic punctuation before word",
+			runs: []*docs.TextRun{
+				{Content: "say ", TextStyle: &docs.TextStyle{}},
+				{Content: "\"hi\"", TextStyle: italic},
+				{Content: "s", TextStyle: &docs.TextStyle{}},
+			},
+			wantMd:   "say <em>\"hi\"</em>s",
+			wantHTML: "say <em>&quot;hi&quot;</em>s",
+		},
+		{
+			name: "strikethrough punctuation inside word",
+			runs: []*docs.TextRun{
+				{Content: "a", TextStyle: &docs.TextStyle{}},
+				{Content: "-b-", TextStyle: &docs.TextStyle{Strikethrough: true}},
+				{Content: "c", TextStyle: &docs.TextStyle{}},
+			},
+			wantMd:   "a<del>-b-</del>c",
+			wantHTML: "a<del>-b-</del>c",
+		},
+		{
+			name: "bold word inside word",
+			runs: []*docs.TextRun{
+				{Content: "un", TextStyle: &docs.TextStyle{}},
+				{Content: "bold", TextStyle: bold},
+				{Content: "ed", TextStyle: &docs.TextStyle{}},
+			},
+			wantMd:   "un**bold**ed",
+			wantHTML: "un<strong>bold</strong>ed",
+		},
+		{
+			name: "italic overlapping bold",
+			runs: []*docs.TextRun{
+				{Content: "a", TextStyle: italic},
+				{Content: "b", TextStyle: boldItalic},
+				{Content: "c", TextStyle: bold},
+			},
+			wantMd:   "*a**b***<strong>c</strong>",
+			wantHTML: "<em>a<strong>b</strong></em><strong>c</strong>",
+		},
+		{
+			name: "bold overlapping italic",
+			runs: []*docs.TextRun{
+				{Content: "foo", TextStyle: bold},
+				{Content: "bar", TextStyle: boldItalic},
+				{Content: "baz", TextStyle: italic},
+			},
+			wantMd:   "**foo*bar***<em>baz</em>",
+			wantHTML: "<strong>foo<em>bar</em></strong><em>baz</em>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := NewMarkdownConverter().processTextRuns(tt.runs...)
+			if md != tt.wantMd {
+				t.Errorf("processTextRuns() = %q, want %q", md, tt.wantMd)
+			}
+			if got := markdownToHTML(md); got != tt.wantHTML {
+				t.Errorf("Markdown %q renders as %q, want %q", md, got, tt.wantHTML)
+			}
+		})
+	}
+}
+
+func TestEscapeMarkdownRoundTrip(t *testing.T) {
+	texts := []string{
+		"snake_case and *stars* [brackets]",
+		"1. not a list",
+		"Tom & Jerry &amp; co",
+		"back\\slash `tick`",
+	}
+	var content []*docs.StructuralElement
+	for _, text := range texts {
+		content = append(content, paragraph("NORMAL_TEXT", textRun(text+"\n")))
+	}
+	ref := &docs.FootnoteReference{FootnoteId: "kix.a", FootnoteNumber: "1"}
+	content = append(content, paragraph("NORMAL_TEXT", textRun("note"), &docs.ParagraphElement{FootnoteReference: ref}, textRun("\n")))
+	doc := &docs.Document{
+		Title:     "Doc",
+		Body:      &docs.Body{Content: content},
+		Footnotes: map[string]docs.Footnote{"kix.a": {Content: []*docs.StructuralElement{paragraph("NORMAL_TEXT", textRun("a_b *c*\n"))}}},
+	}
+	md, err := NewMarkdownConverter().AsMarkdown(doc)
+	if err != nil {
+		t.Fatalf("AsMarkdown: %v", err)
+	}
+	requests := markdownRequests(t, NewDocConverter(), string(md))
+	want := "Doc\n" + strings.Join(texts, "\n") + "\nnote" + "a_b *c*"
+	if got := insertedText(requests); got != want {
+		t.Errorf("Markdown %q inserts %q, want %q", md, got, want)
+	}
+}
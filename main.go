@@ -2,74 +2,241 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.comt/tmc/gdocsmd/auth"
 	"github.comt/tmc/gdocsmd/convert"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 type Options struct {
-	GoogleDocID string
-	MDFile      string
-	TokenFile   string
-	Direction   string
-	Credentials string
+	GoogleDocID    string
+	MDFile         string
+	TokenFile      string
+	Direction      string
+	Credentials    string
+	AssetsDir      string
+	HeadersFooters string
+	FrontMatter    bool
+	PageBreak      string
+	TOCMarker      string
+	ChipTemplates  convert.ChipTemplates
+	Comments       string
+	CriticMarkup   bool
+	Suggestions    string
+	Tab            string
+	Tabs           string
+	LineBreaks     string
 }
 
 type App struct {
 	Client *docs.Service
+	Drive  *drive.Service
 }
 
 func (a *App) Run(ctx context.Context, opts Options) error {
+	var (
+		md []byte
+		fm convert.FrontMatter
+	)
+	if opts.Direction == "to-doc" {
+		c, err := ioutil.ReadFile(opts.MDFile)
+		if err != nil {
+			return fmt.Errorf("unable to read md file: %w", err)
+		}
+		if fm, _, err = convert.SplitFrontMatter(c); err != nil {
+			return fmt.Errorf("unable to parse front matter: %w", err)
+		}
+		if opts.GoogleDocID == "" {
+			opts.GoogleDocID = fm.DocumentID
+		}
+		if opts.Tab == "" {
+			opts.Tab = fm.TabID
+		}
+		md = c
+	}
 	if opts.GoogleDocID == "" {
 		return fmt.Errorf("missing google doc id")
 	}
-	doc, err := a.Client.Documents.Get(opts.GoogleDocID).Do()
+	suggestions := convert.SuggestionsInline
+	switch opts.Suggestions {
+	case "", "inline":
+	case "accept":
+		suggestions = convert.SuggestionsAccepted
+	case "reject":
+		suggestions = convert.SuggestionsRejected
+	default:
+		return fmt.Errorf("invalid suggestions mode: %s", opts.Suggestions)
+	}
+	doc, err := a.Client.Documents.Get(opts.GoogleDocID).SuggestionsViewMode(suggestions.ViewMode()).IncludeTabsContent(true).Do()
 	if err != nil {
 		return fmt.Errorf("unable to retrieve data from document: %w", err)
 	}
+	var tab *docs.Tab
+	if opts.Tab != "" {
+		if tab = convert.FindTab(doc, opts.Tab); tab == nil {
+			return fmt.Errorf("no tab with ID or title %q", opts.Tab)
+		}
+	}
 
 	switch opts.Direction {
 	case "to-md":
-		md, err := convert.NewMarkdownConverter().AsMarkdown(doc)
-		if err != nil {
-			return fmt.Errorf("unable to marshal md: %w", err)
+		mc := convert.NewMarkdownConverter()
+		mc.AssetsDir = opts.AssetsDir
+		mc.MarkdownDir = filepath.Dir(opts.MDFile)
+		mc.FrontMatter = opts.FrontMatter
+		mc.PageBreakMarker = opts.PageBreak
+		mc.ChipTemplates = opts.ChipTemplates
+		switch opts.HeadersFooters {
+		case "", "omit":
+			mc.HeadersFooters = convert.HeadersFootersOmitted
+		case "front-matter":
+			mc.HeadersFooters = convert.HeadersFootersFrontMatter
+		case "sections":
+			mc.HeadersFooters = convert.HeadersFootersSections
+		default:
+			return fmt.Errorf("invalid headers mode: %s", opts.HeadersFooters)
+		}
+		switch opts.Comments {
+		case "", "omit":
+			mc.CommentStyle = convert.CommentsOmitted
+		case "footnotes":
+			mc.CommentStyle = convert.CommentsFootnotes
+		case "html":
+			mc.CommentStyle = convert.CommentsHTML
+		default:
+			return fmt.Errorf("invalid comments mode: %s", opts.Comments)
 		}
-		if err := os.WriteFile(opts.MDFile, []byte(md), 0644); err != nil {
-			return fmt.Errorf("unable to write to md file: %w", err)
+		mc.Comments = &convert.DriveCommentService{Service: a.Drive}
+		mc.CriticMarkup = opts.CriticMarkup
+		mc.Suggestions = suggestions
+		switch opts.LineBreaks {
+		case "", "backslash":
+			mc.LineBreaks = convert.LineBreaksBackslash
+		case "spaces":
+			mc.LineBreaks = convert.LineBreaksSpaces
+		default:
+			return fmt.Errorf("invalid line breaks mode: %s", opts.LineBreaks)
+		}
+		switch {
+		case tab != nil:
+			md, err := mc.TabAsMarkdown(doc, tab)
+			if err != nil {
+				return fmt.Errorf("unable to marshal md: %w", err)
+			}
+			if err := os.WriteFile(opts.MDFile, md, 0644); err != nil {
+				return fmt.Errorf("unable to write to md file: %w", err)
+			}
+		case opts.Tabs == "files":
+			for _, tab := range convert.DocumentTabs(doc) {
+				md, err := mc.TabAsMarkdown(doc, tab)
+				if err != nil {
+					return fmt.Errorf("unable to marshal md: %w", err)
+				}
+				if err := os.WriteFile(tabFile(opts.MDFile, tab), md, 0644); err != nil {
+					return fmt.Errorf("unable to write to md file: %w", err)
+				}
+			}
+		case opts.Tabs == "" || opts.Tabs == "sections":
+			md, err := mc.AsMarkdown(doc)
+			if err != nil {
+				return fmt.Errorf("unable to marshal md: %w", err)
+			}
+			if err := os.WriteFile(opts.MDFile, md, 0644); err != nil {
+				return fmt.Errorf("unable to write to md file: %w", err)
+			}
+		default:
+			return fmt.Errorf("invalid tabs mode: %s", opts.Tabs)
 		}
 	case "to-doc":
-		c, err := ioutil.ReadFile(opts.MDFile)
-		if err != nil {
-			return fmt.Errorf("unable to read md file: %w", err)
+		dc := convert.NewDocConverter()
+		dc.ImageBaseDir = filepath.Dir(opts.MDFile)
+		dc.PageBreakMarker = opts.PageBreak
+		dc.TOCMarker = opts.TOCMarker
+		if tab == nil && len(doc.Tabs) > 0 {
+			tab = doc.Tabs[0]
 		}
-		return convert.MarkdownToDoc(
+		gdoc := doc
+		if tab != nil {
+			dc.TabID = tab.TabProperties.TabId
+			gdoc = convert.TabDocument(doc, tab)
+		}
+		if err := dc.MarkdownToDoc(
 			ctx,
-			&convert.RealDocumentService{a.Client},
+			&convert.RealDocumentService{Service: a.Client},
 			convert.NewMarkdownParser(),
-			doc,
-			c,
-		)
+			gdoc,
+			md,
+		); err != nil {
+			return err
+		}
+		// The document is renamed last so that a token without Drive
+		// access does not keep its content from being written.
+		if fm.Title != "" && fm.Title != doc.Title {
+			if _, err := a.Drive.Files.Update(doc.DocumentId, &drive.File{Name: fm.Title}).Do(); err != nil {
+				return fmt.Errorf("unable to rename document: %w", err)
+			}
+		}
 	default:
 		return fmt.Errorf("invalid direction: %s", opts.Direction)
 	}
 	return nil
 }
 
+var unsafeTabTitleChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// tabFile returns the file a tab is exported to, named after mdFile and the
+// tab's title.
+func tabFile(mdFile string, tab *docs.Tab) string {
+	name := tab.TabProperties.TabId
+	if slug := strings.Trim(unsafeTabTitleChars.ReplaceAllString(strings.ToLower(tab.TabProperties.Title), "-"), "-"); slug != "" {
+		name = slug
+	}
+	ext := filepath.Ext(mdFile)
+	return strings.TrimSuffix(mdFile, ext) + "-" + name + ext
+}
+
+// scopes are the OAuth scopes requested: the documents themselves, Drive
+// metadata to rename documents after their front matter title, and read-only
+// Drive access to list comments. The same token is used for both
+// directions, so all of them are requested at once.
+var scopes = []string{
+	docs.DocumentsScope,
+	drive.DriveMetadataScope,
+	drive.DriveReadonlyScope,
+}
+
+// reauthorizeHint adds a hint to re-authorize to errors caused by a token
+// granted without the scopes a request needs, such as tokens saved before
+// Drive access was requested.
+func reauthorizeHint(err error, tokenFile string) error {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusForbidden && strings.Contains(gerr.Message, "scopes") {
+		return fmt.Errorf("%w (delete %s and run again to re-authorize)", err, tokenFile)
+	}
+	return err
+}
+
 func NewApp(ctx context.Context, opts Options) (*App, error) {
 	b, err := os.ReadFile(opts.Credentials)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read client secret file: %w", err)
 	}
 
-	config, err := google.ConfigFromJSON(b, docs.DocumentsScope)
+	config, err := google.ConfigFromJSON(b, scopes...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
 	}
@@ -80,24 +247,60 @@ func NewApp(ctx context.Context, opts Options) (*App, error) {
 		return nil, fmt.Errorf("unable to retrieve Docs client: %w", err)
 	}
 
-	return &App{Client: srv}, nil
+	driveSrv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Drive client: %w", err)
+	}
+
+	return &App{Client: srv, Drive: driveSrv}, nil
 }
 
 func main() {
 	flagGoogleDocID := flag.String("doc", "", "Google Doc ID")
 	flagMDFile := flag.String("md", "", "Markdown file")
-	flagTokenFile := flag.String("token", "token.json", "Token file")
+	flagTokenFile := flag.String("token", "token.json", "Token file (delete it to re-authorize, e.g. after an upgrade needing Drive access)")
 	flagDirection := flag.String("direction", "to-md", "Direction of conversion (to-md or to-doc)")
 	flagCredentials := flag.String("credentials", "client-secret.json", "Credentials file")
+	flagAssetsDir := flag.String("assets", "", "Directory to download images into (to-md)")
+	flagHeadersFooters := flag.String("headers", "omit", "How to export headers and footers: omit, front-matter or sections (to-md)")
+	flagFrontMatter := flag.Bool("front-matter", true, "Write document metadata as YAML front matter (to-md)")
+
+	flagPageBreak := flag.String("page-break", convert.DefaultPageBreakMarker, "Markdown block standing for a page break")
+	flagTOCMarker := flag.String("toc", convert.DefaultTOCMarker, "Markdown block to generate a table of contents in place of (to-doc)")
+	flagPersonTemplate := flag.String("person-template", convert.DefaultChipTemplates.Person, "Template for person chips (to-md)")
+	flagRichLinkTemplate := flag.String("rich-link-template", convert.DefaultChipTemplates.RichLink, "Template for rich link chips (to-md)")
+	flagDateTemplate := flag.String("date-template", convert.DefaultChipTemplates.Date, "Template for date chips (to-md)")
+	flagComments := flag.String("comments", "omit", "How to export comments: omit, footnotes or html (to-md)")
+	flagCriticMarkup := flag.Bool("critic-markup", false, "Mark suggested insertions and deletions with CriticMarkup (to-md)")
+	flagSuggestions := flag.String("suggestions", "inline", "How to export pending suggestions: accept, reject or inline (to-md)")
+	flagTab := flag.String("tab", "", "ID or title of the tab to export or write to")
+	flagTabs := flag.String("tabs", "sections", "How to export documents with several tabs: sections or files (to-md)")
+	flagLineBreaks := flag.String("line-breaks", "backslash", "How to export line breaks within paragraphs: backslash or spaces (to-md)")
 
 	flag.Parse()
 
 	opts := Options{
-		GoogleDocID: *flagGoogleDocID,
-		MDFile:      *flagMDFile,
-		TokenFile:   *flagTokenFile,
-		Direction:   *flagDirection,
-		Credentials: *flagCredentials,
+		GoogleDocID:    *flagGoogleDocID,
+		MDFile:         *flagMDFile,
+		TokenFile:      *flagTokenFile,
+		Direction:      *flagDirection,
+		Credentials:    *flagCredentials,
+		AssetsDir:      *flagAssetsDir,
+		HeadersFooters: *flagHeadersFooters,
+		FrontMatter:    *flagFrontMatter,
+		PageBreak:      *flagPageBreak,
+		TOCMarker:      *flagTOCMarker,
+		ChipTemplates: convert.ChipTemplates{
+			Person:   *flagPersonTemplate,
+			RichLink: *flagRichLinkTemplate,
+			Date:     *flagDateTemplate,
+		},
+		Comments:     *flagComments,
+		CriticMarkup: *flagCriticMarkup,
+		Suggestions:  *flagSuggestions,
+		Tab:          *flagTab,
+		Tabs:         *flagTabs,
+		LineBreaks:   *flagLineBreaks,
 	}
 
 	ctx := context.Background()
@@ -107,6 +310,6 @@ func main() {
 	}
 
 	if err := app.Run(ctx, opts); err != nil {
-		log.Fatal(err)
+		log.Fatal(reauthorizeHint(err, opts.TokenFile))
 	}
 }